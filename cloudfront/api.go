@@ -8,6 +8,10 @@ import (
 )
 
 type ClientAPI interface {
+	GetDistribution(
+		ctx context.Context, params *cloudfront.GetDistributionInput, optFns ...func(*cloudfront.Options),
+	) (*cloudfront.GetDistributionOutput, error)
+
 	GetDistributionConfig(
 		ctx context.Context, params *cloudfront.GetDistributionConfigInput, optFns ...func(*cloudfront.Options),
 	) (*cloudfront.GetDistributionConfigOutput, error)
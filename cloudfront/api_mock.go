@@ -7,6 +7,9 @@ import (
 )
 
 type MockClient struct {
+	GetDistributionFunc func(
+		ctx context.Context, params *cloudfront.GetDistributionInput, optFns ...func(*cloudfront.Options),
+	) (*cloudfront.GetDistributionOutput, error)
 	GetDistributionConfigFunc func(
 		ctx context.Context, params *cloudfront.GetDistributionConfigInput, optFns ...func(*cloudfront.Options),
 	) (*cloudfront.GetDistributionConfigOutput, error)
@@ -17,6 +20,16 @@ type MockClient struct {
 
 var _ ClientAPI = &MockClient{}
 
+// GetDistribution implements ClientAPI.
+func (m *MockClient) GetDistribution(
+	ctx context.Context, params *cloudfront.GetDistributionInput, optFns ...func(*cloudfront.Options),
+) (*cloudfront.GetDistributionOutput, error) {
+	if m.GetDistributionFunc != nil {
+		return m.GetDistributionFunc(ctx, params, optFns...)
+	}
+	return nil, nil
+}
+
 // DescribeSecret implements ClientAPI.
 func (m *MockClient) GetDistributionConfig(
 	ctx context.Context, params *cloudfront.GetDistributionConfigInput, optFns ...func(*cloudfront.Options),
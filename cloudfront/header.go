@@ -0,0 +1,124 @@
+package cloudfront
+
+import (
+	"errors"
+	"net/http"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+var (
+	ErrNoOriginMatched = errors.New("no origin matched")
+)
+
+// Origin is an alias for "github.com/aws/aws-sdk-go-v2/service/cloudfront/types.Origin"
+type Origin = types.Origin
+
+// OriginMatches reports whether the given origin ID or domain name matches any of the given patterns
+// (see path.Match), i.e. 'api-origin' or '*.lambda-url.*.on.aws'. Any origin matches an empty set of patterns.
+func OriginMatches(origin Origin, patterns ...string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		for _, name := range []string{aws.ToString(origin.Id), aws.ToString(origin.DomainName)} {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HeaderMutation is a change of a custom header of the distribution origins.
+type HeaderMutation struct {
+	// Origins are the ID or domain name patterns of the targeted origins (see OriginMatches).
+	// If empty, all the origins are targeted.
+	Origins []string
+
+	Header string
+	Value  string
+
+	// AddMissing adds the header to the targeted origins that don't have it.
+	// Otherwise, only the origins that already have the header are changed.
+	AddMissing bool
+
+	// Remove removes the header from the targeted origins, Value is ignored.
+	Remove bool
+}
+
+// MutationReport reports the origins (IDs) affected by a HeaderMutation.
+type MutationReport struct {
+	// Matched are the targeted origins that have the header, or get it.
+	Matched []string
+
+	// Changed are the matched origins whose header actually changed.
+	Changed []string
+}
+
+// Fn returns a function that applies the mutation to a distribution config. The report, if not nil,
+// is reset and filled each time the function is applied, i.e. on update retries.
+func (m HeaderMutation) Fn(report *MutationReport) func(*DistributionConfig) {
+	name := http.CanonicalHeaderKey(m.Header)
+
+	return func(dc *DistributionConfig) {
+		if report == nil {
+			report = &MutationReport{}
+		}
+		*report = MutationReport{}
+
+		if dc == nil || dc.Origins == nil {
+			return
+		}
+		for i, origin := range dc.Origins.Items {
+			if !OriginMatches(origin, m.Origins...) {
+				continue
+			}
+			if changed, ok := m.apply(&dc.Origins.Items[i], name); ok {
+				id := aws.ToString(origin.Id)
+				report.Matched = append(report.Matched, id)
+				if changed {
+					report.Changed = append(report.Changed, id)
+				}
+			}
+		}
+	}
+}
+
+// apply applies the mutation to the given origin. It reports whether the origin is matched,
+// that is it has or gets the header, and whether it changed.
+func (m HeaderMutation) apply(origin *Origin, name string) (changed, ok bool) {
+	if headers := origin.CustomHeaders; headers != nil {
+		for j, h := range headers.Items {
+			if http.CanonicalHeaderKey(aws.ToString(h.HeaderName)) != name {
+				continue
+			}
+			if m.Remove {
+				headers.Items = append(headers.Items[:j:j], headers.Items[j+1:]...)
+				headers.Quantity = aws.Int32(int32(len(headers.Items)))
+				return true, true
+			}
+			if aws.ToString(h.HeaderValue) == m.Value {
+				return false, true
+			}
+			headers.Items[j].HeaderValue = aws.String(m.Value)
+			return true, true
+		}
+	}
+
+	if !m.AddMissing || m.Remove {
+		return false, false
+	}
+	if origin.CustomHeaders == nil {
+		origin.CustomHeaders = &types.CustomHeaders{}
+	}
+	headers := origin.CustomHeaders
+	headers.Items = append(headers.Items, types.OriginCustomHeader{
+		HeaderName:  aws.String(m.Header),
+		HeaderValue: aws.String(m.Value),
+	})
+	headers.Quantity = aws.Int32(int32(len(headers.Items)))
+	return true, true
+}
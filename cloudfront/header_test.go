@@ -0,0 +1,125 @@
+package cloudfront
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+func TestHeaderMutation(t *testing.T) {
+	newConfig := func() *DistributionConfig {
+		return &DistributionConfig{
+			Origins: &types.Origins{Items: []types.Origin{
+				{
+					Id:         aws.String("api"),
+					DomainName: aws.String("abcdefgh.lambda-url.eu-west-1.on.aws"),
+					CustomHeaders: &types.CustomHeaders{
+						Quantity: aws.Int32(2),
+						Items: []types.OriginCustomHeader{
+							{HeaderName: aws.String("x-origin-key"), HeaderValue: aws.String("cur")},
+							{HeaderName: aws.String("X-Other"), HeaderValue: aws.String("other")},
+						},
+					},
+				},
+				{
+					Id:         aws.String("admin"),
+					DomainName: aws.String("ijklmnop.lambda-url.eu-west-1.on.aws"),
+				},
+				{
+					Id:         aws.String("assets"),
+					DomainName: aws.String("bucket.s3.eu-west-1.amazonaws.com"),
+				},
+			}},
+		}
+	}
+
+	headers := func(dc *DistributionConfig) string {
+		origins := []string{}
+		for _, o := range dc.Origins.Items {
+			hs := []string{}
+			if o.CustomHeaders != nil {
+				if want, got := len(o.CustomHeaders.Items), int(aws.ToInt32(o.CustomHeaders.Quantity)); want != got {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+				for _, h := range o.CustomHeaders.Items {
+					hs = append(hs, aws.ToString(h.HeaderName)+"="+aws.ToString(h.HeaderValue))
+				}
+			}
+			origins = append(origins, aws.ToString(o.Id)+":"+strings.Join(hs, ","))
+		}
+		return strings.Join(origins, " ")
+	}
+
+	type tc struct {
+		name     string
+		mutation HeaderMutation
+		headers  string
+		matched  string
+		changed  string
+	}
+
+	tcs := []tc{
+		{
+			name:     "update existing header",
+			mutation: HeaderMutation{Header: "X-Origin-Key", Value: "pen"},
+			headers:  "api:x-origin-key=pen,X-Other=other admin: assets:",
+			matched:  "api",
+			changed:  "api",
+		},
+		{
+			name:     "update with the same value",
+			mutation: HeaderMutation{Header: "X-Origin-Key", Value: "cur"},
+			headers:  "api:x-origin-key=cur,X-Other=other admin: assets:",
+			matched:  "api",
+		},
+		{
+			name:     "header name typo",
+			mutation: HeaderMutation{Header: "X-Orign-Key", Value: "pen"},
+			headers:  "api:x-origin-key=cur,X-Other=other admin: assets:",
+		},
+		{
+			name:     "add missing header to targeted origins",
+			mutation: HeaderMutation{Origins: []string{"*.lambda-url.*.on.aws"}, Header: "X-Origin-Key", Value: "pen", AddMissing: true},
+			headers:  "api:x-origin-key=pen,X-Other=other admin:X-Origin-Key=pen assets:",
+			matched:  "api admin",
+			changed:  "api admin",
+		},
+		{
+			name:     "target origin by ID",
+			mutation: HeaderMutation{Origins: []string{"assets"}, Header: "X-Origin-Key", Value: "pen", AddMissing: true},
+			headers:  "api:x-origin-key=cur,X-Other=other admin: assets:X-Origin-Key=pen",
+			matched:  "assets",
+			changed:  "assets",
+		},
+		{
+			name:     "remove header",
+			mutation: HeaderMutation{Header: "X-Origin-Key", Remove: true, AddMissing: true},
+			headers:  "api:X-Other=other admin: assets:",
+			matched:  "api",
+			changed:  "api",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dc := newConfig()
+			report := &MutationReport{Matched: []string{"stale"}}
+			fn := tc.mutation.Fn(report)
+			// the function is applied again, as on update retries
+			fn(newConfig())
+			fn(dc)
+
+			if want, got := tc.headers, headers(dc); want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := tc.matched, strings.Join(report.Matched, " "); want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := tc.changed, strings.Join(report.Changed, " "); want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		})
+	}
+}
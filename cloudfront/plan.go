@@ -0,0 +1,115 @@
+package cloudfront
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// HeaderChange is a change of an origin custom header. Header values are secrets,
+// so they are identified by their fingerprint (see Fingerprint).
+type HeaderChange struct {
+	OriginID string
+	Header   string
+
+	// OldFingerprint is empty if the header is added.
+	OldFingerprint string
+
+	// NewFingerprint is empty if the header is removed.
+	NewFingerprint string
+}
+
+func (c HeaderChange) String() string {
+	switch {
+	case c.OldFingerprint == "":
+		return fmt.Sprintf("origin %s: add %s (%s)", c.OriginID, c.Header, c.NewFingerprint)
+	case c.NewFingerprint == "":
+		return fmt.Sprintf("origin %s: remove %s (%s)", c.OriginID, c.Header, c.OldFingerprint)
+	default:
+		return fmt.Sprintf("origin %s: update %s (%s -> %s)", c.OriginID, c.Header, c.OldFingerprint, c.NewFingerprint)
+	}
+}
+
+// Plan is the set of changes a distribution config update makes.
+type Plan struct {
+	DistributionID string
+
+	// Changes are the origin custom header changes, sorted by origin ID and header.
+	Changes []HeaderChange
+
+	// Changed reports whether the config changes at all, origin custom headers included.
+	// The distribution isn't updated otherwise.
+	Changed bool
+}
+
+func (p *Plan) String() string {
+	if !p.Changed {
+		return fmt.Sprintf("distribution %s: no change", p.DistributionID)
+	}
+	changes := make([]string, 0, len(p.Changes))
+	for _, c := range p.Changes {
+		changes = append(changes, c.String())
+	}
+	if len(changes) == 0 {
+		changes = append(changes, "config changed")
+	}
+	return fmt.Sprintf("distribution %s: %s", p.DistributionID, strings.Join(changes, "; "))
+}
+
+// Fingerprint returns a short, non reversible, identifier of the given header value.
+func Fingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+type originHeader struct {
+	originID, header string
+}
+
+// originHeaders returns the fingerprints of the origin custom headers of the given config.
+func originHeaders(dc *DistributionConfig) map[originHeader]string {
+	headers := map[originHeader]string{}
+	if dc == nil || dc.Origins == nil {
+		return headers
+	}
+	for _, origin := range dc.Origins.Items {
+		if origin.CustomHeaders == nil {
+			continue
+		}
+		for _, h := range origin.CustomHeaders.Items {
+			k := originHeader{
+				originID: aws.ToString(origin.Id),
+				header:   http.CanonicalHeaderKey(aws.ToString(h.HeaderName)),
+			}
+			headers[k] = Fingerprint(aws.ToString(h.HeaderValue))
+		}
+	}
+	return headers
+}
+
+// diffHeaders returns the changes between the given origin custom headers fingerprints.
+func diffHeaders(before, after map[originHeader]string) []HeaderChange {
+	changes := []HeaderChange{}
+	for k, old := range before {
+		if nw := after[k]; nw != old {
+			changes = append(changes, HeaderChange{OriginID: k.originID, Header: k.header, OldFingerprint: old, NewFingerprint: nw})
+		}
+	}
+	for k, nw := range after {
+		if _, ok := before[k]; !ok {
+			changes = append(changes, HeaderChange{OriginID: k.originID, Header: k.header, NewFingerprint: nw})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].OriginID != changes[j].OriginID {
+			return changes[i].OriginID < changes[j].OriginID
+		}
+		return changes[i].Header < changes[j].Header
+	})
+	return changes
+}
@@ -1,44 +1,83 @@
 package cloudfront
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
-	"net/http"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
 	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/aws/smithy-go"
 )
 
 var (
 	ErrUpdateFunctionIsmissing = errors.New("update function is missing")
+
+	ErrUpdateConflict  = errors.New("distribution config changed concurrently")
+	ErrUpdateThrottled = errors.New("distribution update throttled")
 )
 
+// UpdateError is a distribution update failure, after one or more attempts.
+// It matches its kind (i.e. ErrUpdateConflict) using errors.Is, and unwraps to the final cause.
+type UpdateError struct {
+	DistributionID string
+	Attempts       int
+	Err            error
+
+	kind error
+}
+
+func (e *UpdateError) Error() string {
+	return fmt.Sprintf("update distribution %s failed after %d attempt(s): %v", e.DistributionID, e.Attempts, e.Err)
+}
+
+func (e *UpdateError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UpdateError) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
+
+// classifyUpdateError returns the kind of the given update error, or nil if it's not retryable.
+func classifyUpdateError(err error) error {
+	var pf *types.PreconditionFailed
+	var ae smithy.APIError
+	switch {
+	case errors.As(err, &pf):
+		return ErrUpdateConflict
+	case errors.As(err, &ae):
+		code := ae.ErrorCode()
+		if _, ok := retry.DefaultThrottleErrorCodes[code]; ok || code == "TooManyDistributionChanges" {
+			return ErrUpdateThrottled
+		}
+	}
+	return nil
+}
+
+const (
+	// StatusDeployed is the status of a distribution whose config is propagated to all edge locations.
+	StatusDeployed = "Deployed"
+)
+
+// Distribution is an alias for "github.com/aws/aws-sdk-go-v2/service/cloudfront/types.Distribution"
+type Distribution = types.Distribution
+
 // DistributionConfig is an alias for "github.com/aws/aws-sdk-go-v2/service/cloudfront/types.DistributionConfig"
 type DistributionConfig = types.DistributionConfig
 
 // UpdateCustomHeaderFn returns a function that iterates over the distribution origins
 // and updates the given custom header value if the header is found at the origin config level.
+// See HeaderMutation to target specific origins, add missing headers or report the matched origins.
 func UpdateCustomHeaderFn(headerName, headerValue string) func(*DistributionConfig) {
-	headerName = http.CanonicalHeaderKey(headerName)
-
-	return func(dc *DistributionConfig) {
-		if dc.Origins == nil || len(dc.Origins.Items) == 0 {
-			return
-		}
-		for i, origin := range dc.Origins.Items {
-			if origin.CustomHeaders == nil || len(origin.CustomHeaders.Items) == 0 {
-				continue
-			}
-			for j, h := range origin.CustomHeaders.Items {
-				hname := http.CanonicalHeaderKey(aws.ToString(h.HeaderName))
-				if hname == headerName {
-					dc.Origins.Items[i].CustomHeaders.Items[j].HeaderValue = aws.String(headerValue)
-					break
-				}
-			}
-		}
-	}
+	return HeaderMutation{Header: headerName, Value: headerValue}.Fn(nil)
 }
 
 // Updater interface presents a service that updates a cloudfront distribution config.
@@ -46,33 +85,156 @@ type Updater interface {
 	// Update fetches the distribution config and updates it using a set of functions.
 	// An empty set of functions behavior is implementation-specific.
 	Update(ctx context.Context, distID string, fns ...func(*DistributionConfig)) error
+
+	// WaitDeployed blocks until the distribution changes are deployed, or the context is done.
+	WaitDeployed(ctx context.Context, distID string) error
+
+	// Get returns the distribution, including its domain name, status and config.
+	Get(ctx context.Context, distID string) (*Distribution, error)
+
+	// Plan returns the changes the update functions would make to the distribution config, without updating it.
+	Plan(ctx context.Context, distID string, fns ...func(*DistributionConfig)) (*Plan, error)
+}
+
+type UpdaterConfig struct {
+	// PollInterval is the period between two distribution status checks.
+	PollInterval time.Duration
+
+	// MaxAttempts is the maximum number of update attempts. Conflicting updates are retried right away
+	// on top of a fresh config, throttled updates are retried after an exponential backoff with jitter.
+	MaxAttempts int
+
+	// RetryTimeout bounds the overall duration of an update, retries included. Zero means no bound
+	// other than the context deadline.
+	RetryTimeout time.Duration
+
+	// BaseDelay and MaxDelay bound the backoff of the throttled updates.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
 }
 
 type DefaultUpdater struct {
 	client ClientAPI
+	cfg    *UpdaterConfig
+
+	mu   sync.Mutex
+	rand *rand.Rand
 }
 
 var _ Updater = &DefaultUpdater{}
 
-func NewDefaultUpdater(cli ClientAPI) *DefaultUpdater {
-	return &DefaultUpdater{client: cli}
+func NewDefaultUpdater(cli ClientAPI, opts ...func(*UpdaterConfig)) *DefaultUpdater {
+	cfg := &UpdaterConfig{
+		PollInterval: 15 * time.Second,
+		MaxAttempts:  5,
+		RetryTimeout: 2 * time.Minute,
+		BaseDelay:    time.Second,
+		MaxDelay:     20 * time.Second,
+	}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		opt(cfg)
+	}
+
+	return &DefaultUpdater{
+		client: cli,
+		cfg:    cfg,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
 }
 
-// Update implements the Updater interface
+// Update implements the Updater interface.
+// It fails with an UpdateError once the update isn't retryable, or the attempts are exhausted.
 func (u *DefaultUpdater) Update(ctx context.Context, distID string, fns ...func(*DistributionConfig)) error {
 	// TODO: use the new generic slice function to filter nil values
 	if len(fns) == 0 {
 		return ErrUpdateFunctionIsmissing
 	}
 
+	if u.cfg.RetryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.cfg.RetryTimeout)
+		defer cancel()
+	}
+
+	uerr := &UpdateError{DistributionID: distID}
+	for {
+		uerr.Attempts++
+		uerr.Err = u.update(ctx, distID, fns...)
+		if uerr.Err == nil {
+			return nil
+		}
+		uerr.kind = classifyUpdateError(uerr.Err)
+		if uerr.kind == nil || uerr.Attempts >= u.cfg.MaxAttempts {
+			return uerr
+		}
+
+		delay := time.Duration(0)
+		if uerr.kind == ErrUpdateThrottled {
+			delay = u.backoff(uerr.Attempts)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return uerr
+		}
+		select {
+		case <-ctx.Done():
+			return uerr
+		case <-time.After(delay):
+		}
+	}
+}
+
+// update fetches the distribution config, applies the given functions and updates the distribution
+// if the config didn't change meanwhile. The distribution isn't updated if the functions change nothing.
+func (u *DefaultUpdater) update(ctx context.Context, distID string, fns ...func(*DistributionConfig)) error {
+	p, out, err := u.plan(ctx, distID, fns...)
+	if err != nil {
+		return err
+	}
+	if !p.Changed {
+		return nil
+	}
+
+	if _, err := u.client.UpdateDistribution(ctx, &cloudfront.UpdateDistributionInput{
+		DistributionConfig: out.DistributionConfig,
+		Id:                 aws.String(distID),
+		IfMatch:            out.ETag,
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Plan implements the Updater interface
+func (u *DefaultUpdater) Plan(ctx context.Context, distID string, fns ...func(*DistributionConfig)) (*Plan, error) {
+	if len(fns) == 0 {
+		return nil, ErrUpdateFunctionIsmissing
+	}
+	p, _, err := u.plan(ctx, distID, fns...)
+	return p, err
+}
+
+// plan fetches the distribution config and applies the given functions. It returns the fetched output,
+// whose config is updated, and the plan of the changes.
+func (u *DefaultUpdater) plan(ctx context.Context, distID string, fns ...func(*DistributionConfig)) (*Plan, *cloudfront.GetDistributionConfigOutput, error) {
 	out, err := u.client.GetDistributionConfig(ctx, &cloudfront.GetDistributionConfigInput{
 		Id: aws.String(distID),
 	})
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	distConfig := out.DistributionConfig
 
+	before, err := json.Marshal(distConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := originHeaders(distConfig)
+
 	for _, fn := range fns {
 		if fn == nil {
 			continue
@@ -80,13 +242,70 @@ func (u *DefaultUpdater) Update(ctx context.Context, distID string, fns ...func(
 		fn(distConfig)
 	}
 
-	if _, err := u.client.UpdateDistribution(ctx, &cloudfront.UpdateDistributionInput{
-		DistributionConfig: distConfig,
-		Id:                 aws.String(distID),
-		IfMatch:            out.ETag,
-	}); err != nil {
-		return err
+	after, err := json.Marshal(distConfig)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil
+	return &Plan{
+		DistributionID: distID,
+		Changes:        diffHeaders(headers, originHeaders(distConfig)),
+		Changed:        !bytes.Equal(before, after),
+	}, out, nil
+}
+
+// backoff returns a random delay up to the exponential backoff of the given attempt (full jitter).
+func (u *DefaultUpdater) backoff(attempt int) time.Duration {
+	max := u.cfg.BaseDelay
+	for i := 1; i < attempt && max < u.cfg.MaxDelay; i++ {
+		max *= 2
+	}
+	if max > u.cfg.MaxDelay {
+		max = u.cfg.MaxDelay
+	}
+	if max <= 0 {
+		return 0
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return time.Duration(u.rand.Int63n(int64(max)))
+}
+
+// WaitDeployed implements the Updater interface
+func (u *DefaultUpdater) WaitDeployed(ctx context.Context, distID string) error {
+	ticker := time.NewTicker(u.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		out, err := u.client.GetDistribution(ctx, &cloudfront.GetDistributionInput{
+			Id: aws.String(distID),
+		})
+		if err != nil {
+			return err
+		}
+		if out.Distribution != nil && aws.ToString(out.Distribution.Status) == StatusDeployed {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("distribution %s not deployed: %w", distID, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Get implements the Updater interface
+func (u *DefaultUpdater) Get(ctx context.Context, distID string) (*Distribution, error) {
+	out, err := u.client.GetDistribution(ctx, &cloudfront.GetDistributionInput{
+		Id: aws.String(distID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Distribution == nil {
+		return nil, fmt.Errorf("distribution %s not found", distID)
+	}
+	return out.Distribution, nil
 }
@@ -6,7 +6,10 @@ import (
 
 // MockUpdater is a mock implementation of the Updater interface.
 type MockUpdater struct {
-	UpdateFn func(ctx context.Context, distID string, fns ...func(*DistributionConfig)) error
+	UpdateFn       func(ctx context.Context, distID string, fns ...func(*DistributionConfig)) error
+	WaitDeployedFn func(ctx context.Context, distID string) error
+	GetFn          func(ctx context.Context, distID string) (*Distribution, error)
+	PlanFn         func(ctx context.Context, distID string, fns ...func(*DistributionConfig)) (*Plan, error)
 }
 
 // Update mocks the Update method.
@@ -16,3 +19,27 @@ func (m *MockUpdater) Update(ctx context.Context, distID string, fns ...func(*Di
 	}
 	return nil
 }
+
+// WaitDeployed mocks the WaitDeployed method.
+func (m *MockUpdater) WaitDeployed(ctx context.Context, distID string) error {
+	if m.WaitDeployedFn != nil {
+		return m.WaitDeployedFn(ctx, distID)
+	}
+	return nil
+}
+
+// Get mocks the Get method.
+func (m *MockUpdater) Get(ctx context.Context, distID string) (*Distribution, error) {
+	if m.GetFn != nil {
+		return m.GetFn(ctx, distID)
+	}
+	return &Distribution{}, nil
+}
+
+// Plan mocks the Plan method.
+func (m *MockUpdater) Plan(ctx context.Context, distID string, fns ...func(*DistributionConfig)) (*Plan, error) {
+	if m.PlanFn != nil {
+		return m.PlanFn(ctx, distID, fns...)
+	}
+	return &Plan{DistributionID: distID}, nil
+}
@@ -3,12 +3,15 @@ package cloudfront
 import (
 	"context"
 	"errors"
+	"strconv"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
 	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/aws/smithy-go"
 )
 
 func TestUpdater(t *testing.T) {
@@ -70,7 +73,7 @@ func TestUpdater(t *testing.T) {
 										Items: []types.OriginCustomHeader{
 											{
 												HeaderName:  &headerName,
-												HeaderValue: &headerValue,
+												HeaderValue: aws.String("old_value"),
 											},
 										},
 									},
@@ -113,4 +116,273 @@ func TestUpdater(t *testing.T) {
 			t.Fatalf("expect 'spyUpdates' be called once")
 		}
 	})
+	t.Run("with wait deployed", func(t *testing.T) {
+		spyCalls := int32(0)
+
+		cli := &MockClient{
+			GetDistributionFunc: func(ctx context.Context, params *cloudfront.GetDistributionInput, optFns ...func(*cloudfront.Options)) (*cloudfront.GetDistributionOutput, error) {
+				status := "InProgress"
+				if atomic.AddInt32(&spyCalls, 1) == 3 {
+					status = StatusDeployed
+				}
+				return &cloudfront.GetDistributionOutput{
+					Distribution: &types.Distribution{Status: aws.String(status)},
+				}, nil
+			},
+		}
+
+		u := NewDefaultUpdater(cli, func(uc *UpdaterConfig) {
+			uc.PollInterval = 10 * time.Millisecond
+		})
+
+		if err := u.WaitDeployed(ctx, distID); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := int32(3), spyCalls; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+
+		// the distribution is never deployed
+		atomic.StoreInt32(&spyCalls, 10)
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		if want, got := context.DeadlineExceeded, u.WaitDeployed(ctx, distID); !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with get distribution", func(t *testing.T) {
+		cli := &MockClient{
+			GetDistributionFunc: func(ctx context.Context, params *cloudfront.GetDistributionInput, optFns ...func(*cloudfront.Options)) (*cloudfront.GetDistributionOutput, error) {
+				if aws.ToString(params.Id) != distID {
+					return &cloudfront.GetDistributionOutput{}, nil
+				}
+				return &cloudfront.GetDistributionOutput{
+					Distribution: &types.Distribution{DomainName: aws.String("d111111abcdef8.cloudfront.net")},
+				}, nil
+			},
+		}
+
+		u := NewDefaultUpdater(cli)
+
+		dist, err := u.Get(ctx, distID)
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := "d111111abcdef8.cloudfront.net", aws.ToString(dist.DomainName); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if _, err := u.Get(ctx, "other"); err == nil {
+			t.Fatal("expect err be not nil")
+		}
+	})
+}
+
+func TestUpdater_Plan(t *testing.T) {
+	ctx := context.Background()
+	distID := "fake_dist"
+
+	updates := 0
+	cli := &MockClient{
+		GetDistributionConfigFunc: func(ctx context.Context, params *cloudfront.GetDistributionConfigInput, optFns ...func(*cloudfront.Options)) (*cloudfront.GetDistributionConfigOutput, error) {
+			return &cloudfront.GetDistributionConfigOutput{
+				DistributionConfig: &types.DistributionConfig{
+					Comment: aws.String("comment"),
+					Origins: &types.Origins{Items: []types.Origin{
+						{
+							Id: aws.String("api"),
+							CustomHeaders: &types.CustomHeaders{Items: []types.OriginCustomHeader{
+								{HeaderName: aws.String("x-origin-key"), HeaderValue: aws.String("cur")},
+							}},
+						},
+						{
+							Id: aws.String("assets"),
+						},
+					}},
+				},
+			}, nil
+		},
+		UpdateDistributionFunc: func(ctx context.Context, params *cloudfront.UpdateDistributionInput, optFns ...func(*cloudfront.Options)) (*cloudfront.UpdateDistributionOutput, error) {
+			updates++
+			return &cloudfront.UpdateDistributionOutput{}, nil
+		},
+	}
+
+	u := NewDefaultUpdater(cli)
+
+	t.Run("plan header change", func(t *testing.T) {
+		p, err := u.Plan(ctx, distID, UpdateCustomHeaderFn("X-Origin-Key", "pen"))
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if !p.Changed {
+			t.Fatal("expect plan be changed")
+		}
+		if want, got := 1, len(p.Changes); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		want := HeaderChange{OriginID: "api", Header: "X-Origin-Key", OldFingerprint: Fingerprint("cur"), NewFingerprint: Fingerprint("pen")}
+		if got := p.Changes[0]; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		// the plan is a dry run
+		if want, got := 0, updates; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("plan other config change", func(t *testing.T) {
+		p, err := u.Plan(ctx, distID, func(dc *DistributionConfig) {
+			dc.Comment = aws.String("other comment")
+		})
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if !p.Changed || len(p.Changes) != 0 {
+			t.Fatalf("expect plan be changed without header changes, got %v", p)
+		}
+	})
+
+	t.Run("skip no-op update", func(t *testing.T) {
+		for _, fn := range []func(*DistributionConfig){
+			UpdateCustomHeaderFn("X-Origin-Key", "cur"),
+			UpdateCustomHeaderFn("X-Other-Key", "pen"),
+		} {
+			p, err := u.Plan(ctx, distID, fn)
+			if err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+			if p.Changed {
+				t.Fatalf("expect plan be unchanged, got %v", p)
+			}
+			if err := u.Update(ctx, distID, fn); err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+		}
+		if want, got := 0, updates; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+}
+
+func TestUpdater_Retry(t *testing.T) {
+	ctx := context.Background()
+	distID := "fake_dist"
+
+	headerName := "X-Custom-H"
+	newConfig := func(value string) *DistributionConfig {
+		return &DistributionConfig{
+			Origins: &types.Origins{Items: []types.Origin{{
+				CustomHeaders: &types.CustomHeaders{Items: []types.OriginCustomHeader{
+					{HeaderName: aws.String(headerName), HeaderValue: aws.String(value)},
+				}},
+			}}},
+		}
+	}
+
+	conflictErr := &types.PreconditionFailed{Message: aws.String("etag mismatch")}
+	throttleErr := &smithy.GenericAPIError{Code: "Throttling"}
+	tooManyErr := &smithy.GenericAPIError{Code: "TooManyDistributionChanges"}
+	infraErr := errors.New("infra error")
+
+	type tc struct {
+		name         string
+		errs         []error
+		retryTimeout time.Duration
+		attempts     int
+		err          error
+	}
+
+	tcs := []tc{
+		{
+			name:     "retry conflicting update",
+			errs:     []error{conflictErr, conflictErr},
+			attempts: 3,
+		},
+		{
+			name:     "retry throttled update",
+			errs:     []error{throttleErr, tooManyErr},
+			attempts: 3,
+		},
+		{
+			name:     "attempts exhausted",
+			errs:     []error{conflictErr, throttleErr, conflictErr, throttleErr, tooManyErr},
+			attempts: 4,
+			err:      ErrUpdateThrottled,
+		},
+		{
+			name:     "not retryable error",
+			errs:     []error{infraErr},
+			attempts: 1,
+			err:      infraErr,
+		},
+		{
+			name:         "retry timeout",
+			errs:         []error{throttleErr, throttleErr, throttleErr},
+			retryTimeout: time.Millisecond,
+			attempts:     1,
+			err:          throttleErr,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			etag, value, reads, updates := 0, "old", 0, 0
+			cli := &MockClient{
+				GetDistributionConfigFunc: func(ctx context.Context, params *cloudfront.GetDistributionConfigInput, optFns ...func(*cloudfront.Options)) (*cloudfront.GetDistributionConfigOutput, error) {
+					reads++
+					return &cloudfront.GetDistributionConfigOutput{
+						DistributionConfig: newConfig(value),
+						ETag:               aws.String(strconv.Itoa(etag)),
+					}, nil
+				},
+				UpdateDistributionFunc: func(ctx context.Context, params *cloudfront.UpdateDistributionInput, optFns ...func(*cloudfront.Options)) (*cloudfront.UpdateDistributionOutput, error) {
+					defer func() { updates++ }()
+					if updates < len(tc.errs) {
+						// a concurrent deployment changes the config
+						etag++
+						return nil, tc.errs[updates]
+					}
+					if want, got := strconv.Itoa(etag), aws.ToString(params.IfMatch); want != got {
+						return nil, conflictErr
+					}
+					value = aws.ToString(params.DistributionConfig.Origins.Items[0].CustomHeaders.Items[0].HeaderValue)
+					return &cloudfront.UpdateDistributionOutput{}, nil
+				},
+			}
+
+			u := NewDefaultUpdater(cli, func(uc *UpdaterConfig) {
+				uc.MaxAttempts = 4
+				uc.BaseDelay = time.Millisecond
+				uc.MaxDelay = 5 * time.Millisecond
+				if tc.retryTimeout != 0 {
+					uc.RetryTimeout = tc.retryTimeout
+					uc.BaseDelay, uc.MaxDelay = time.Second, time.Second
+				}
+			})
+
+			err := u.Update(ctx, distID, UpdateCustomHeaderFn(headerName, "new"))
+			if want, got := tc.err, err; !errors.Is(got, want) {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			// the config is read again before each attempt
+			if want, got := tc.attempts, reads; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if tc.err == nil {
+				if want, got := "new", value; want != got {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+				return
+			}
+			var uerr *UpdateError
+			if !errors.As(err, &uerr) {
+				t.Fatalf("expect err be UpdateError, got %v", err)
+			}
+			if want, got := tc.attempts, uerr.Attempts; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		})
+	}
 }
@@ -1,18 +1,19 @@
 module github.com/ln80/secure-lambda-url
 
-go 1.19
+go 1.21
 
 require (
 	github.com/aws/aws-sdk-go-v2 v1.20.1
 	github.com/aws/aws-sdk-go-v2/service/cloudfront v1.27.0
 	github.com/aws/aws-sdk-go-v2/service/secretsmanager v1.19.12
+	github.com/aws/aws-sdk-go-v2/service/ssm v1.37.2
+	github.com/aws/smithy-go v1.14.1
+	golang.org/x/sync v0.3.0
 )
 
 require (
 	github.com/aws/aws-sdk-go-v2/internal/configsources v1.1.38 // indirect
 	github.com/aws/aws-sdk-go-v2/internal/endpoints/v2 v2.4.32 // indirect
-	github.com/aws/smithy-go v1.14.1 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
-	golang.org/x/sync v0.3.0 // indirect
 )
@@ -0,0 +1,208 @@
+package rotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/ln80/secure-lambda-url/cloudfront"
+)
+
+type CloudFrontTargetConfig struct {
+	// Origins are the ID or domain name patterns of the origins holding the custom header,
+	// i.e. '*.lambda-url.*.on.aws' (see cloudfront.OriginMatches). If empty, all the origins are targeted.
+	Origins []string
+
+	// AddMissing adds the custom header to the targeted origins that don't have it yet.
+	AddMissing bool
+
+	// ProbePath is the path requested once the distribution is deployed, it must answer 2xx to GET requests
+	// and shouldn't be cached by the distribution. If empty, the pending value isn't probed.
+	ProbePath string
+
+	// FunctionURL is the origin URL probed directly, i.e. https://<url-id>.lambda-url.<region>.on.aws.
+	// Default to the distribution origins holding the custom header.
+	FunctionURL string
+
+	// ProbeTimeout is the period reserved to the probes: the deployment wait gives up this period
+	// before the context deadline.
+	ProbeTimeout time.Duration
+
+	// HTTPClient sends the probe requests.
+	HTTPClient *http.Client
+}
+
+// CloudFrontTarget is a Target that holds the secret value in a custom header of the distribution origins.
+type CloudFrontTarget struct {
+	updater      cloudfront.Updater
+	distID       string
+	customHeader string
+	cfg          *CloudFrontTargetConfig
+}
+
+var _ Target = &CloudFrontTarget{}
+
+// NewCloudFrontTarget returns a target that updates the given custom header of the distribution origins.
+func NewCloudFrontTarget(updater cloudfront.Updater, distID, customHeader string, opts ...func(*CloudFrontTargetConfig)) *CloudFrontTarget {
+	cfg := &CloudFrontTargetConfig{
+		ProbeTimeout: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		opt(cfg)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: cfg.ProbeTimeout / 3}
+	}
+
+	return &CloudFrontTarget{
+		updater:      updater,
+		distID:       distID,
+		customHeader: customHeader,
+		cfg:          cfg,
+	}
+}
+
+// Set implements Target. It updates the origins custom header with the pending value.
+func (t *CloudFrontTarget) Set(ctx context.Context, current, pending string) error {
+	return t.update(ctx, pending)
+}
+
+// Test implements Target.
+//
+// It waits until the distribution changes are deployed, or fails with ErrNotReady before the context deadline.
+// Then, if ProbePath is configured, it probes the pending value end-to-end: the origin must answer 2xx
+// through the distribution and to the pending value, but not to requests without any value.
+func (t *CloudFrontTarget) Test(ctx context.Context, pending string) error {
+	waitCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithDeadline(ctx, deadline.Add(-t.cfg.ProbeTimeout))
+		defer cancel()
+	}
+	if err := t.updater.WaitDeployed(waitCtx, t.distID); err != nil {
+		err = fmt.Errorf("cloudfront distribution %s: %w", t.distID, err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return &targetError{kind: ErrNotReady, err: err}
+		}
+		return err
+	}
+
+	if t.cfg.ProbePath == "" {
+		return nil
+	}
+
+	dist, err := t.updater.Get(ctx, t.distID)
+	if err != nil {
+		return fmt.Errorf("cloudfront distribution %s: %w", t.distID, err)
+	}
+
+	// the distribution sends the pending value to the origins
+	if err := t.probe(ctx, "https://"+aws.ToString(dist.DomainName), "", true); err != nil {
+		return err
+	}
+	for _, url := range t.originURLs(dist) {
+		if err := t.probe(ctx, url, pending, true); err != nil {
+			return err
+		}
+		if err := t.probe(ctx, url, "", false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback implements Target. It updates the origins custom header with the current value.
+// If no origin holds the header, i.e. its name is misspelled, the pending value was never set,
+// so there is nothing to roll back.
+func (t *CloudFrontTarget) Rollback(ctx context.Context, current string) error {
+	if err := t.update(ctx, current); err != nil && !errors.Is(err, cloudfront.ErrNoOriginMatched) {
+		return err
+	}
+	return nil
+}
+
+// update sets the custom header of the targeted origins to the given value.
+// It fails with cloudfront.ErrNoOriginMatched if no origin holds the header, i.e. its name is misspelled.
+func (t *CloudFrontTarget) update(ctx context.Context, value string) error {
+	report := &cloudfront.MutationReport{}
+	m := cloudfront.HeaderMutation{
+		Origins:    t.cfg.Origins,
+		Header:     t.customHeader,
+		Value:      value,
+		AddMissing: t.cfg.AddMissing,
+	}
+	if err := t.updater.Update(ctx, t.distID, m.Fn(report)); err != nil {
+		return fmt.Errorf("cloudfront distribution %s: %w", t.distID, err)
+	}
+	if len(report.Matched) == 0 {
+		return fmt.Errorf("cloudfront distribution %s: %w: header %s", t.distID, cloudfront.ErrNoOriginMatched, t.customHeader)
+	}
+	return nil
+}
+
+// originURLs returns the URLs of the origins probed directly: either the configured function URL,
+// or the targeted distribution origins holding the custom header.
+func (t *CloudFrontTarget) originURLs(dist *cloudfront.Distribution) []string {
+	if t.cfg.FunctionURL != "" {
+		return []string{t.cfg.FunctionURL}
+	}
+	if dist.DistributionConfig == nil || dist.DistributionConfig.Origins == nil {
+		return nil
+	}
+	name := http.CanonicalHeaderKey(t.customHeader)
+	urls := []string{}
+	for _, origin := range dist.DistributionConfig.Origins.Items {
+		if origin.CustomHeaders == nil || !cloudfront.OriginMatches(origin, t.cfg.Origins...) {
+			continue
+		}
+		for _, h := range origin.CustomHeaders.Items {
+			if http.CanonicalHeaderKey(aws.ToString(h.HeaderName)) == name {
+				urls = append(urls, "https://"+aws.ToString(origin.DomainName)+aws.ToString(origin.OriginPath))
+				break
+			}
+		}
+	}
+	return urls
+}
+
+// probe requests the probe path of the given URL, with the given custom header value if not empty,
+// and checks whether the response status is 2xx as expected. Network failures, throttling and server errors
+// fail with ErrNotReady, other unexpected statuses with ErrProbeFailed.
+func (t *CloudFrontTarget) probe(ctx context.Context, url, value string, wantOK bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+t.cfg.ProbePath, nil)
+	if err != nil {
+		return &targetError{kind: ErrProbeFailed, err: err}
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+	if value != "" {
+		req.Header.Set(t.customHeader, value)
+	}
+
+	// a network failure is transient, the probe is retried with the rotation
+	resp, err := t.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return &targetError{kind: ErrNotReady, err: err}
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if ok := resp.StatusCode >= 200 && resp.StatusCode < 300; ok != wantOK {
+		with := "without key"
+		if value != "" {
+			with = "with pending key"
+		}
+		err := fmt.Errorf("GET %s %s answered %d", req.URL, with, resp.StatusCode)
+		if wantOK && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500) {
+			return &targetError{kind: ErrNotReady, err: err}
+		}
+		return &targetError{kind: ErrProbeFailed, err: err}
+	}
+	return nil
+}
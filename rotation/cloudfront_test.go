@@ -0,0 +1,226 @@
+package rotation
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/ln80/secure-lambda-url/cloudfront"
+)
+
+func TestCloudFrontTarget(t *testing.T) {
+	ctx := context.Background()
+
+	header := ""
+	infraErr := errors.New("infra error")
+	updater := &cloudfront.MockUpdater{
+		UpdateFn: func(ctx context.Context, distID string, fns ...func(*cloudfront.DistributionConfig)) error {
+			if distID != "dist" {
+				return infraErr
+			}
+			dc := &cloudfront.DistributionConfig{
+				Origins: &types.Origins{Items: []types.Origin{{
+					CustomHeaders: &types.CustomHeaders{Items: []types.OriginCustomHeader{
+						{HeaderName: aws.String("X-Origin-Key"), HeaderValue: aws.String(header)},
+					}},
+				}}},
+			}
+			for _, fn := range fns {
+				fn(dc)
+			}
+			header = aws.ToString(dc.Origins.Items[0].CustomHeaders.Items[0].HeaderValue)
+			return nil
+		},
+	}
+
+	target := NewCloudFrontTarget(updater, "dist", "x-origin-key")
+	if err := target.Set(ctx, "cur", "pen"); err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+	if want, got := "pen", header; want != got {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+	if err := target.Test(ctx, "pen"); err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+	if err := target.Rollback(ctx, "cur"); err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+	if want, got := "cur", header; want != got {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+
+	if err := NewCloudFrontTarget(updater, "other", "x-origin-key").Set(ctx, "cur", "pen"); !errors.Is(err, infraErr) {
+		t.Fatalf("expect %v, %v be equals", infraErr, err)
+	}
+
+	// the header name is misspelled, there is nothing to roll back
+	misspelled := NewCloudFrontTarget(updater, "dist", "x-orign-key")
+	if err := misspelled.Set(ctx, "cur", "pen"); !errors.Is(err, cloudfront.ErrNoOriginMatched) {
+		t.Fatalf("expect %v, %v be equals", cloudfront.ErrNoOriginMatched, err)
+	}
+	if err := misspelled.Rollback(ctx, "cur"); err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+	// the header is added to the targeted origins
+	target = NewCloudFrontTarget(updater, "dist", "x-new-key", func(c *CloudFrontTargetConfig) {
+		c.AddMissing = true
+	})
+	if err := target.Set(ctx, "cur", "pen"); err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+}
+
+func TestCloudFrontTarget_Test(t *testing.T) {
+	ctx := context.Background()
+
+	dist := &cloudfront.Distribution{
+		DomainName: aws.String("d111111abcdef8.cloudfront.net"),
+		DistributionConfig: &cloudfront.DistributionConfig{
+			Origins: &types.Origins{Items: []types.Origin{
+				{
+					DomainName: aws.String("abcdefgh.lambda-url.eu-west-1.on.aws"),
+					CustomHeaders: &types.CustomHeaders{Items: []types.OriginCustomHeader{
+						{HeaderName: aws.String("X-Origin-Key"), HeaderValue: aws.String("pen")},
+					}},
+				},
+				{
+					DomainName: aws.String("bucket.s3.amazonaws.com"),
+				},
+			}},
+		},
+	}
+
+	type tc struct {
+		name      string
+		accepted  func(r *http.Request) bool
+		rejected  int
+		probePath string
+		deployed  bool
+		err       error
+		requests  int32
+	}
+
+	tcs := []tc{
+		{
+			name:      "pending key accepted",
+			accepted:  func(r *http.Request) bool { return r.Header.Get("X-Origin-Key") == "pen" },
+			probePath: "/health",
+			deployed:  true,
+			requests:  3,
+		},
+		{
+			name:      "pending key rejected",
+			accepted:  func(r *http.Request) bool { return r.Header.Get("X-Origin-Key") == "cur" },
+			probePath: "/health",
+			deployed:  true,
+			err:       ErrProbeFailed,
+			requests:  1,
+		},
+		{
+			name:      "origin answers without key",
+			accepted:  func(r *http.Request) bool { return true },
+			probePath: "/health",
+			deployed:  true,
+			err:       ErrProbeFailed,
+			requests:  3,
+		},
+		{
+			name:      "origin unavailable",
+			accepted:  func(r *http.Request) bool { return false },
+			rejected:  http.StatusServiceUnavailable,
+			probePath: "/health",
+			deployed:  true,
+			err:       ErrNotReady,
+			requests:  1,
+		},
+		{
+			name:     "probe disabled",
+			accepted: func(r *http.Request) bool { return false },
+			deployed: true,
+		},
+		{
+			name:      "deployment in progress",
+			accepted:  func(r *http.Request) bool { return true },
+			probePath: "/health",
+			err:       ErrNotReady,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := int32(0)
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				if r.URL.Path != "/health" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if r.Host == aws.ToString(dist.DomainName) {
+					// the distribution sends the deployed custom header to the origin
+					r.Header.Set("X-Origin-Key", "pen")
+				}
+				if !tc.accepted(r) {
+					status := http.StatusForbidden
+					if tc.rejected != 0 {
+						status = tc.rejected
+					}
+					w.WriteHeader(status)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			// all the probed hosts are served by the test server
+			client := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+				},
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			}}
+
+			updater := &cloudfront.MockUpdater{
+				WaitDeployedFn: func(ctx context.Context, distID string) error {
+					if tc.deployed {
+						return nil
+					}
+					<-ctx.Done()
+					return ctx.Err()
+				},
+				GetFn: func(ctx context.Context, distID string) (*cloudfront.Distribution, error) {
+					return dist, nil
+				},
+			}
+
+			target := NewCloudFrontTarget(updater, "dist", "X-Origin-Key", func(c *CloudFrontTargetConfig) {
+				c.ProbePath = tc.probePath
+				c.ProbeTimeout = 50 * time.Millisecond
+				c.HTTPClient = client
+			})
+
+			ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+
+			err := target.Test(ctx, "pen")
+			if want, got := tc.err, err; !errors.Is(got, want) {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := tc.requests, atomic.LoadInt32(&requests); want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			// the deployment wait gives up before the context deadline
+			if tc.err == ErrNotReady && ctx.Err() != nil {
+				t.Fatalf("expect ctx not be done, got %v", ctx.Err())
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package rotation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNotReady is a Test failure of a target that isn't ready yet, i.e. a deployment in progress.
+	// The rotation is expected to be retried rather than rolled back.
+	ErrNotReady = errors.New("target not ready")
+
+	// ErrProbeFailed is a Test failure of a target that answers unexpectedly to the pending value,
+	// i.e. rejects it. The rotation is expected to be rolled back.
+	ErrProbeFailed = errors.New("target probe failed")
+)
+
+// targetError is a target failure. It matches its kind (i.e. ErrNotReady) using errors.Is,
+// and unwraps to its cause.
+type targetError struct {
+	kind error
+	err  error
+}
+
+func (e *targetError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e *targetError) Unwrap() error {
+	return e.err
+}
+
+func (e *targetError) Is(target error) bool {
+	return target == e.kind
+}
+
+// Target presents a downstream system that holds a copy of the rotated secret value,
+// and has to be kept in sync with the secret during the rotation steps.
+//
+// Implementations must be idempotent, as the rotation steps may be retried.
+type Target interface {
+	// Set makes the target accept the pending value. The current value might still be in use
+	// until the rotation is finished.
+	Set(ctx context.Context, current, pending string) error
+
+	// Test checks the pending value is effectively used by the target.
+	Test(ctx context.Context, pending string) error
+
+	// Rollback restores the current value of the target.
+	Rollback(ctx context.Context, current string) error
+}
+
+// Errors aggregates the errors of a fan-out to a set of targets.
+// It matches any of its errors using errors.Is.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Targets fans out to a set of targets concurrently. It returns the Errors of the failed targets, if any.
+type Targets []Target
+
+var _ Target = Targets{}
+
+// Set implements Target.
+func (ts Targets) Set(ctx context.Context, current, pending string) error {
+	return ts.each(func(t Target) error {
+		return t.Set(ctx, current, pending)
+	})
+}
+
+// Test implements Target.
+func (ts Targets) Test(ctx context.Context, pending string) error {
+	return ts.each(func(t Target) error {
+		return t.Test(ctx, pending)
+	})
+}
+
+// Rollback implements Target.
+func (ts Targets) Rollback(ctx context.Context, current string) error {
+	return ts.each(func(t Target) error {
+		return t.Rollback(ctx, current)
+	})
+}
+
+func (ts Targets) each(fn func(t Target) error) error {
+	errs := make([]error, len(ts))
+
+	var wg sync.WaitGroup
+	for i, t := range ts {
+		if t == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, t Target) {
+			defer wg.Done()
+			errs[i] = fn(t)
+		}(i, t)
+	}
+	wg.Wait()
+
+	var failed Errors
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return failed
+}
@@ -0,0 +1,36 @@
+package rotation
+
+import (
+	"context"
+)
+
+// MockTarget is a mock implementation of the Target interface.
+type MockTarget struct {
+	SetFn      func(ctx context.Context, current, pending string) error
+	TestFn     func(ctx context.Context, pending string) error
+	RollbackFn func(ctx context.Context, current string) error
+}
+
+// Set mocks the Set method.
+func (m *MockTarget) Set(ctx context.Context, current, pending string) error {
+	if m.SetFn != nil {
+		return m.SetFn(ctx, current, pending)
+	}
+	return nil
+}
+
+// Test mocks the Test method.
+func (m *MockTarget) Test(ctx context.Context, pending string) error {
+	if m.TestFn != nil {
+		return m.TestFn(ctx, pending)
+	}
+	return nil
+}
+
+// Rollback mocks the Rollback method.
+func (m *MockTarget) Rollback(ctx context.Context, current string) error {
+	if m.RollbackFn != nil {
+		return m.RollbackFn(ctx, current)
+	}
+	return nil
+}
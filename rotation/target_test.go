@@ -0,0 +1,90 @@
+package rotation
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTargets(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("fan out concurrently", func(t *testing.T) {
+		started := int32(0)
+		release := make(chan struct{})
+		block := func(ctx context.Context, current, pending string) error {
+			if atomic.AddInt32(&started, 1) == 3 {
+				close(release)
+			}
+			select {
+			case <-release:
+				return nil
+			case <-time.After(time.Second):
+				return errors.New("targets are set sequentially")
+			}
+		}
+		targets := Targets{
+			&MockTarget{SetFn: block},
+			&MockTarget{SetFn: block},
+			&MockTarget{SetFn: block},
+		}
+
+		if err := targets.Set(ctx, "cur", "pen"); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+	})
+
+	t.Run("aggregate errors", func(t *testing.T) {
+		err1, err2 := errors.New("cdn error"), errors.New("client error")
+		rolledBack := int32(0)
+		targets := Targets{
+			&MockTarget{
+				TestFn: func(ctx context.Context, pending string) error { return err1 },
+				RollbackFn: func(ctx context.Context, current string) error {
+					atomic.AddInt32(&rolledBack, 1)
+					return nil
+				},
+			},
+			&MockTarget{
+				TestFn: func(ctx context.Context, pending string) error { return nil },
+				RollbackFn: func(ctx context.Context, current string) error {
+					atomic.AddInt32(&rolledBack, 1)
+					return err2
+				},
+			},
+			nil,
+		}
+
+		err := targets.Test(ctx, "pen")
+		if !errors.Is(err, err1) {
+			t.Fatalf("expect %v, %v be equals", err1, err)
+		}
+		if errors.Is(err, err2) {
+			t.Fatalf("expect err %v not be %v", err, err2)
+		}
+
+		err = targets.Rollback(ctx, "cur")
+		var errs Errors
+		if !errors.As(err, &errs) {
+			t.Fatalf("expect err be Errors, got %v", err)
+		}
+		if want, got := 1, len(errs); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if !errors.Is(err, err2) {
+			t.Fatalf("expect %v, %v be equals", err2, err)
+		}
+		// the failure of a target doesn't prevent the others
+		if want, got := int32(2), rolledBack; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("no target", func(t *testing.T) {
+		if err := (Targets{}).Set(ctx, "cur", "pen"); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+	})
+}
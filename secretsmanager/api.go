@@ -30,6 +30,10 @@ type ClientAPI interface {
 		context.Context, *secretsmanager.UpdateSecretVersionStageInput,
 		...func(*secretsmanager.Options),
 	) (*secretsmanager.UpdateSecretVersionStageOutput, error)
+
+	TagResource(
+		context.Context, *secretsmanager.TagResourceInput, ...func(*secretsmanager.Options),
+	) (*secretsmanager.TagResourceOutput, error)
 }
 
 var _ ClientAPI = &secretsmanager.Client{}
@@ -12,6 +12,7 @@ type MockClient struct {
 	PutSecretValueFunc           func(context.Context, *secretsmanager.PutSecretValueInput, ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error)
 	DescribeSecretFunc           func(context.Context, *secretsmanager.DescribeSecretInput, ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error)
 	UpdateSecretVersionStageFunc func(context.Context, *secretsmanager.UpdateSecretVersionStageInput, ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error)
+	TagResourceFunc              func(context.Context, *secretsmanager.TagResourceInput, ...func(*secretsmanager.Options)) (*secretsmanager.TagResourceOutput, error)
 }
 
 var _ ClientAPI = &MockClient{}
@@ -54,3 +55,11 @@ func (m *MockClient) GetRandomPassword(ctx context.Context, input *secretsmanage
 	}
 	return nil, nil
 }
+
+// TagResource implements ClientAPI.
+func (m *MockClient) TagResource(ctx context.Context, input *secretsmanager.TagResourceInput, opts ...func(*secretsmanager.Options)) (*secretsmanager.TagResourceOutput, error) {
+	if m.TagResourceFunc != nil {
+		return m.TagResourceFunc(ctx, input, opts...)
+	}
+	return nil, nil
+}
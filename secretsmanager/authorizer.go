@@ -4,44 +4,290 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
-	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
 	ErrInvalidSecretValue  = errors.New("invalid secret value")
 	ErrUnauthorized        = errors.New("unauthorized")
 	ErrAuthorizationFailed = errors.New("authorization failed")
+
+	ErrSourceThrottled    = errors.New("secret source throttled")
+	ErrSourceAccessDenied = errors.New("secret source access denied")
+
+	// ErrInvalidSecret is a secret value that can't be used as configured, i.e. a malformed keyring
+	// or a missing secret field.
+	// Unlike the source failures, it's never tolerated by the availability policy.
+	ErrInvalidSecret = errors.New("invalid secret")
+)
+
+// sourceError is a secret source failure. It matches both ErrAuthorizationFailed
+// and its kind (i.e. ErrSourceThrottled) using errors.Is.
+type sourceError struct {
+	kind error
+	err  error
+}
+
+func (e *sourceError) Error() string {
+	if e.kind == nil || errors.Is(e.err, e.kind) {
+		return fmt.Sprintf("%v: %v", ErrAuthorizationFailed, e.err)
+	}
+	return fmt.Sprintf("%v: %v: %v", ErrAuthorizationFailed, e.kind, e.err)
+}
+
+func (e *sourceError) Unwrap() error {
+	return e.err
+}
+
+func (e *sourceError) Is(target error) bool {
+	return target == ErrAuthorizationFailed || (e.kind != nil && target == e.kind)
+}
+
+// classifyError wraps the given secret source error into a sourceError.
+func classifyError(err error) error {
+	var kind error
+	var ae smithy.APIError
+	switch {
+	case errors.Is(err, ErrSecretNotFound):
+		kind = ErrSecretNotFound
+	case errors.Is(err, os.ErrPermission):
+		kind = ErrSourceAccessDenied
+	case errors.As(err, &ae):
+		code := ae.ErrorCode()
+		if _, ok := retry.DefaultThrottleErrorCodes[code]; ok {
+			kind = ErrSourceThrottled
+		} else if code == "AccessDeniedException" || code == "AccessDenied" {
+			kind = ErrSourceAccessDenied
+		}
+	}
+	return &sourceError{kind: kind, err: err}
+}
+
+// AvailabilityPolicy defines how the authorizer behaves when the secret source fails.
+type AvailabilityPolicy int
+
+const (
+	// FailClosed denies the values that can't be checked without calling the secret source.
+	FailClosed AvailabilityPolicy = iota
+
+	// ServeStale checks the values against the cached secret stages as long as they are not older
+	// than the max staleness. Otherwise it behaves as FailClosed.
+	ServeStale
+
+	// FailOpen authorizes the values that can't be checked without calling the secret source.
+	FailOpen
 )
 
+func (p AvailabilityPolicy) String() string {
+	switch p {
+	case ServeStale:
+		return "serve-stale"
+	case FailOpen:
+		return "fail-open"
+	default:
+		return "fail-closed"
+	}
+}
+
+// Authorizer presents a service that checks whether a value matches a secret value.
+//
+// Deprecated: Authorizer hides the details of the authorization, use DecisionAuthorizer instead.
+// AsAuthorizer adapts a DecisionAuthorizer to this interface.
 type Authorizer interface {
 	Authorize(ctx context.Context, secretID, value string) (err error, remoteCalled bool)
 }
 
+// DecisionAuthorizer presents a service that checks whether a value matches a secret value,
+// and describes how the decision was made.
+// The returned error is nil only if the value is authorized.
+type DecisionAuthorizer interface {
+	Decide(ctx context.Context, secretID, value string) (Decision, error)
+}
+
+// Decision describes the outcome of an authorization.
+type Decision struct {
+	// Allowed reports whether the value is authorized.
+	Allowed bool
+
+	// Stage is the version stage (i.e. AWSCURRENT, AWSPREVIOUS or AWSPENDING) which matched the value.
+	Stage string
+
+	// VersionID is the ID of the secret version which matched the value.
+	VersionID string
+
+	// KeyName is the name of the keyring key which matched the value, it's empty if the secret isn't a keyring.
+	KeyName string
+
+	// Cached reports whether the decision was made using the cached secret values only.
+	Cached bool
+
+	// BlackListed reports whether the value was rejected by the black list.
+	BlackListed bool
+
+	// RemoteCalls is the number of secret API calls made to reach the decision.
+	RemoteCalls int
+
+	// Latency is the time spent to reach the decision.
+	Latency time.Duration
+
+	// Reason explains the denial, it's empty if the value is authorized.
+	Reason string
+
+	// Stale reports whether the decision was made using cached secret values,
+	// after the secret source failed (see ServeStale policy).
+	Stale bool
+
+	// FailedOpen reports whether the value was authorized because the secret source failed (see FailOpen policy).
+	FailedOpen bool
+
+	// SourceErr is the secret source failure tolerated by the availability policy.
+	SourceErr error
+}
+
+// AsAuthorizer adapts the given DecisionAuthorizer to the legacy Authorizer interface.
+func AsAuthorizer(auth DecisionAuthorizer) Authorizer {
+	return authorizerAdapter{auth: auth}
+}
+
+type authorizerAdapter struct {
+	auth DecisionAuthorizer
+}
+
+func (a authorizerAdapter) Authorize(ctx context.Context, secretID, value string) (error, bool) {
+	d, err := a.auth.Decide(ctx, secretID, value)
+	return err, d.RemoteCalls > 0
+}
+
 type AuthorizerConfig struct {
 	// gracePreriod is used to tolerate accepting "Previous" and "Pending" secret version
 	// as valid values for a short period of time.
 	GracePeriod time.Duration
 
+	// DeploymentGracePeriod is the period during which the "Previous" secret version is tolerated after a rotation
+	// whose deployment isn't recorded yet. It requires the secret source to be a SecretDescriber.
+	DeploymentGracePeriod time.Duration
+
 	// coolDownPeriod is period during which we assume the secret can't be rotated.
 	// It's used to rate limit the API calls
 	CoolDownPeriod time.Duration
+
+	// BlackListCapacity is the maximum number of rejected values kept in the black list of each secret.
+	// Least recently used values are evicted first. Zero disables the black list.
+	BlackListCapacity int
+
+	// BlackListTTL is the period during which a rejected value remains black listed.
+	// The black list is also purged whenever a new secret version is observed.
+	BlackListTTL time.Duration
+
+	// Availability is the policy applied when the secret source fails. Missing secrets always fail closed.
+	Availability AvailabilityPolicy
+
+	// MaxStaleness is the maximum age of the cached secret stages used by the ServeStale policy.
+	// Note that the cache is entirely cleared by the Janitor at each interval.
+	MaxStaleness time.Duration
+
+	// FetchTimeout bounds the remote calls shared by concurrent callers, which aren't cancelled along with
+	// the context of the caller making them.
+	FetchTimeout time.Duration
+
+	// SignatureEnabled keeps the plain secret values in cache, it's required to verify request signatures.
+	SignatureEnabled bool
+
+	// ClockSkew is the maximum difference tolerated between a signature timestamp and the current time.
+	// Both ClockSkew and NonceCapacity must be positive to verify signatures.
+	ClockSkew time.Duration
+
+	// NonceCapacity is the maximum number of signature nonces kept per secret to reject replayed requests.
+	// Unexpired nonces are never evicted, signed requests are denied once the capacity is reached.
+	NonceCapacity int
+
+	// TokenIssuer and TokenAudience are the expected 'iss' and 'aud' claims of the tokens, they are optional.
+	TokenIssuer   string
+	TokenAudience string
+
+	// TokenLeeway is the clock skew tolerated when checking the 'exp' and 'nbf' claims of the tokens.
+	TokenLeeway time.Duration
+
+	// Keyring parses the secret values as keyrings of named keys (see Keyring).
+	Keyring bool
+
+	// SecretField is the field holding the value in JSON secret values, either a member name or a JSON pointer
+	// (see SecretField). If empty, the whole secret value is used.
+	SecretField string
+
+	// KeyFormat rejects the values which aren't self-validating keys (see FormatKey) without calling
+	// the secret source, nor black listing them. Values without the key prefix are still checked against
+	// the secret if LegacyKeys is set.
+	KeyFormat  bool
+	LegacyKeys bool
+
+	// VersionAddressed checks the formatted keys against the secret version matching their version hint,
+	// instead of trying the secret stages in turn. The version stages are read from the secret description.
+	// It requires the secret source to be both a SecretDescriber and a VersionFetcher, and KeyFormat to be set.
+	// It doesn't apply to keyrings: only the rotated key holds the hint of the new version, the other keys
+	// are checked against the secret stages in turn.
+	VersionAddressed bool
 }
 
 type DefaultAuthorizer struct {
-	client ClientAPI
+	source SecretSource
 
 	janitor *Janitor
+	group   singleflight.Group
 	cfg     *AuthorizerConfig
+
+	// bls maps secret IDs to their black lists
+	bls  map[string]*blackList
+	blMu sync.Mutex
+
+	// nonces maps secret IDs to their seen signature nonces
+	nonces  map[string]*blackList
+	nonceMu sync.Mutex
+
+	// descs maps secret IDs to their descriptions
+	descs  map[string]description
+	descMu sync.Mutex
+}
+
+type description struct {
+	SecretDescription
+	fetchedAt time.Time
 }
 
+var (
+	_ Authorizer          = &DefaultAuthorizer{}
+	_ DecisionAuthorizer  = &DefaultAuthorizer{}
+	_ SignatureAuthorizer = &DefaultAuthorizer{}
+	_ WebhookAuthorizer   = &DefaultAuthorizer{}
+	_ TokenAuthorizer     = &DefaultAuthorizer{}
+	_ KeyringAuthorizer   = &DefaultAuthorizer{}
+)
+
+// NewAuthorizer returns an authorizer of secretsmanager secrets.
 func NewAuthorizer(cli ClientAPI, j *Janitor, opts ...func(*AuthorizerConfig)) *DefaultAuthorizer {
+	return NewSourceAuthorizer(NewSecretsManagerSource(cli), j, opts...)
+}
+
+// NewSourceAuthorizer returns an authorizer of the secrets held by the given source.
+func NewSourceAuthorizer(src SecretSource, j *Janitor, opts ...func(*AuthorizerConfig)) *DefaultAuthorizer {
 	cfg := &AuthorizerConfig{
-		GracePeriod:    15 * time.Second,
-		CoolDownPeriod: 15 * time.Second,
+		GracePeriod:           15 * time.Second,
+		DeploymentGracePeriod: 15 * time.Minute,
+		CoolDownPeriod:        15 * time.Second,
+		BlackListCapacity:     10000,
+		BlackListTTL:          5 * time.Minute,
+		Availability:          FailClosed,
+		MaxStaleness:          5 * time.Minute,
+		FetchTimeout:          5 * time.Second,
+		ClockSkew:             5 * time.Minute,
+		NonceCapacity:         100000,
+		TokenLeeway:           30 * time.Second,
 	}
 
 	for _, opt := range opts {
@@ -52,92 +298,356 @@ func NewAuthorizer(cli ClientAPI, j *Janitor, opts ...func(*AuthorizerConfig)) *
 	}
 
 	return &DefaultAuthorizer{
-		client:  cli,
+		source:  src,
 		cfg:     cfg,
 		janitor: j,
+		bls:     make(map[string]*blackList),
+		nonces:  make(map[string]*blackList),
+		descs:   make(map[string]description),
 	}
 }
 
+// Authorize implements Authorizer.
 func (a *DefaultAuthorizer) Authorize(ctx context.Context, secretID, value string) (error, bool) {
-	if value == "" {
-		return ErrInvalidSecretValue, false
+	return AsAuthorizer(a).Authorize(ctx, secretID, value)
+}
+
+// Decide implements DecisionAuthorizer.
+//
+// For keyring secrets, it behaves as DecideKey with an empty scope: keys restricted to paths or methods are denied.
+func (a *DefaultAuthorizer) Decide(ctx context.Context, secretID, value string) (Decision, error) {
+	return a.DecideKey(ctx, secretID, value, Scope{})
+}
+
+// decide checks the secret stages against the given match function, starting with the CURRENT stage,
+// then the PREVIOUS and PENDING stages within the grace window.
+// A cached stage is refreshed if it's created before the cool down period, and if throttled is set,
+// only if it's also fetched before the cool down period. Throttling is meant for the denials which aren't
+// black listed, i.e. unique values such as signatures or tokens: the cool down period then applies to
+// the last fetch time, to rate limit the API calls made on behalf of them.
+func (a *DefaultAuthorizer) decide(ctx context.Context, secretID string, d *Decision, throttled bool, matchFn func(s secret) bool) error {
+	// tolerate the source failure according to the availability policy
+	tolerated := func(err error) bool {
+		return !errors.Is(err, ErrSecretNotFound) && !errors.Is(err, ErrInvalidSecret) && a.cfg.Availability != FailClosed
 	}
-	if a.janitor.isBlackListed(value) {
-		return ErrUnauthorized, false
+
+	fail := func(err error, reason string) error {
+		if tolerated(err) && a.cfg.Availability == FailOpen {
+			d.FailedOpen, d.SourceErr = true, err
+			return nil
+		}
+		d.Reason = reason
+		return err
 	}
 
-	cur, prev, pen, _ := a.janitor.getCache()
-	defer func() {
-		// refresh cache values
-		a.janitor.setCache(cur, prev, pen)
-	}()
+	expired := func(s secret) bool {
+		return time.Since(s.createdAt) > a.cfg.CoolDownPeriod &&
+			(!throttled || time.Since(s.fetchedAt) > a.cfg.CoolDownPeriod)
+	}
 
-	getSecret := func(stage string) (secret, error) {
-		out, err := a.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
-			SecretId:     aws.String(secretID),
-			VersionStage: aws.String(stage),
-		})
-		s := secret{}
-		if err != nil {
-			var te *types.ResourceNotFoundException
-			if errors.As(err, &te) {
-				return s, nil
-			}
-			return s, fmt.Errorf("%w: %v", ErrAuthorizationFailed, err)
+	refresh := func(stage string, cached secret) (secret, error) {
+		s, called, err := a.fetch(ctx, secretID, stage, "")
+		if called {
+			d.RemoteCalls++
 		}
-		s.value = aws.ToString(out.SecretString)
-		s.createdAt = aws.ToTime(out.CreatedDate)
+		if err != nil && tolerated(err) && a.cfg.Availability == ServeStale &&
+			!cached.fetchedAt.IsZero() && time.Since(cached.fetchedAt) <= a.cfg.MaxStaleness {
+			d.Stale, d.SourceErr = true, err
+			return cached, nil
+		}
+		return s, err
+	}
 
-		return s, nil
+	match := func(s secret, stage string) bool {
+		if s.IsZero() || !matchFn(s) {
+			return false
+		}
+		d.Stage, d.VersionID, d.Cached = stage, s.versionID, d.RemoteCalls == 0
+		return true
 	}
 
-	if cur.value == value {
-		return nil, false
+	var err error
+	cur := a.janitor.getStage(secretID, VersionCurrent)
+	if match(cur, VersionCurrent) {
+		return nil
 	}
 	// only refresh secret cache value if cool down period is exceeded
-	if time.Since(cur.createdAt) > a.cfg.CoolDownPeriod {
-		var (
-			err error
-		)
-		cur, err = getSecret(VersionCurrent)
+	if expired(cur) {
+		cur, err = refresh(VersionCurrent, cur)
 		if err != nil {
-			return err, true
+			return fail(err, "current version fetch failed")
 		}
-		if cur.value == value {
-			return nil, true
+		if match(cur, VersionCurrent) {
+			return nil
 		}
 	}
 
-	// Grace Period is a short and transitional period
-	// during which checking auth against PREVIOUS and PENDING values is tolerated
-	if time.Since(cur.createdAt) < a.cfg.GracePeriod {
-		remoteCalled := false
-		if time.Since(prev.createdAt) > a.cfg.CoolDownPeriod {
-			var err error
-			prev, err = getSecret(VersionPrevious)
-			remoteCalled = true
+	// Grace window is a transitional period during which checking auth
+	// against PREVIOUS and PENDING values is tolerated
+	previous, pending := a.graceWindow(ctx, secretID, cur, d)
+	if previous {
+		prev := a.janitor.getStage(secretID, VersionPrevious)
+		if expired(prev) {
+			prev, err = refresh(VersionPrevious, prev)
 			if err != nil {
-				return err, remoteCalled
+				return fail(err, "previous version fetch failed")
 			}
 		}
-		if prev.value == value {
-			return nil, remoteCalled
+		if match(prev, VersionPrevious) {
+			return nil
 		}
-
-		if time.Since(pen.createdAt) > a.cfg.CoolDownPeriod {
-			var err error
-			pen, err = getSecret(VersionPending)
-			remoteCalled = true
+	}
+	if pending {
+		pen := a.janitor.getStage(secretID, VersionPending)
+		if expired(pen) {
+			pen, err = refresh(VersionPending, pen)
 			if err != nil {
-				return err, remoteCalled
+				return fail(err, "pending version fetch failed")
+			}
+		}
+		if match(pen, VersionPending) {
+			return nil
+		}
+	}
+
+	if d.Stale {
+		d.Reason = "no matching stale secret version"
+		return ErrUnauthorized
+	}
+	d.Reason = "no matching secret version"
+	return ErrUnauthorized
+}
+
+// graceWindow reports whether the PREVIOUS and PENDING versions of the given secret are tolerated.
+//
+// By default, both are tolerated during the grace period following the current version creation.
+// If the secret source is a SecretDescriber, PENDING is also tolerated as long as it labels a version
+// other than the current one, that is during an ongoing rotation however long the deployment takes: a failed
+// rotation is rolled back, which removes the label. PREVIOUS is tolerated after the last rotation: for the grace
+// period once the current version deployment is recorded, otherwise for the deployment grace period.
+func (a *DefaultAuthorizer) graceWindow(ctx context.Context, secretID string, cur secret, d *Decision) (previous, pending bool) {
+	previous = time.Since(cur.createdAt) < a.cfg.GracePeriod
+	pending = previous
+
+	desc, ok := a.describe(ctx, secretID, cur, d)
+	if !ok || len(desc.VersionStages) == 0 {
+		return
+	}
+
+	for _, stages := range desc.VersionStages {
+		if hasStage(stages, VersionPending) && !hasStage(stages, VersionCurrent) {
+			pending = true
+		}
+	}
+
+	rotatedAt, window := desc.LastRotatedAt, a.cfg.DeploymentGracePeriod
+	if rotatedAt.IsZero() {
+		rotatedAt = cur.createdAt
+	}
+	if desc.DeployedVersionID != "" && desc.DeployedVersionID == cur.versionID {
+		if desc.DeployedAt.After(rotatedAt) {
+			rotatedAt = desc.DeployedAt
+		}
+		window = a.cfg.GracePeriod
+	}
+	if time.Since(rotatedAt) < window {
+		previous = true
+	}
+	return
+}
+
+// describe returns the cached description of the given secret, and refreshes it if either the cool down period
+// is exceeded or the current version is not known yet. It returns false if the description isn't available.
+func (a *DefaultAuthorizer) describe(ctx context.Context, secretID string, cur secret, d *Decision) (SecretDescription, bool) {
+	describer, ok := a.source.(SecretDescriber)
+	if !ok {
+		return SecretDescription{}, false
+	}
+
+	a.descMu.Lock()
+	cached, found := a.descs[secretID]
+	a.descMu.Unlock()
+
+	if found && time.Since(cached.fetchedAt) <= a.cfg.CoolDownPeriod &&
+		(cur.versionID == "" || len(cached.VersionStages) == 0 || hasStage(cached.VersionStages[cur.versionID], VersionCurrent)) {
+		return cached.SecretDescription, true
+	}
+
+	called := false
+	v, err, _ := a.group.Do(secretID+"/describe", func() (interface{}, error) {
+		called = true
+
+		desc, err := describer.Describe(ctx, secretID)
+		if err != nil {
+			return nil, err
+		}
+		a.descMu.Lock()
+		a.descs[secretID] = description{SecretDescription: desc, fetchedAt: time.Now()}
+		a.descMu.Unlock()
+
+		return desc, nil
+	})
+	if called {
+		d.RemoteCalls++
+	}
+	if err != nil {
+		// the description is optional, fall back to the last known one if any
+		return cached.SecretDescription, found
+	}
+	return v.(SecretDescription), true
+}
+
+func hasStage(stages []string, stage string) bool {
+	for _, s := range stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh fetches all the stages of the given secret and updates the cache,
+// as well as the secret description if the secret source is a SecretDescriber.
+func (a *DefaultAuthorizer) Refresh(ctx context.Context, secretID string) error {
+	for _, stage := range []string{VersionCurrent, VersionPrevious, VersionPending} {
+		if _, _, err := a.fetch(ctx, secretID, stage, ""); err != nil {
+			return err
+		}
+	}
+	if _, ok := a.source.(SecretDescriber); ok {
+		if _, err := a.rotated(ctx, secretID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotated describes the given secret, and reports whether the version stages differ from the cached ones.
+// The secret description cache is updated as well.
+func (a *DefaultAuthorizer) rotated(ctx context.Context, secretID string) (bool, error) {
+	describer, ok := a.source.(SecretDescriber)
+	if !ok {
+		return false, nil
+	}
+
+	desc, err := describer.Describe(ctx, secretID)
+	if err != nil {
+		return false, classifyError(err)
+	}
+	a.descMu.Lock()
+	a.descs[secretID] = description{SecretDescription: desc, fetchedAt: time.Now()}
+	a.descMu.Unlock()
+
+	if len(desc.VersionStages) == 0 {
+		return false, nil
+	}
+	for _, stage := range []string{VersionCurrent, VersionPrevious, VersionPending} {
+		versionID := ""
+		for id, stages := range desc.VersionStages {
+			if hasStage(stages, stage) {
+				versionID = id
+				break
 			}
 		}
-		if pen.value == value {
-			return nil, remoteCalled
+		if a.janitor.getStage(secretID, stage).versionID != versionID {
+			return true, nil
 		}
 	}
+	return false, nil
+}
+
+// blackList returns the black list of the given secret.
+func (a *DefaultAuthorizer) blackList(secretID string) *blackList {
+	a.blMu.Lock()
+	defer a.blMu.Unlock()
+
+	bl, ok := a.bls[secretID]
+	if !ok {
+		bl = newBlackList(a.cfg.BlackListCapacity, a.cfg.BlackListTTL)
+		a.bls[secretID] = bl
+	}
+	return bl
+}
 
-	a.janitor.blackList(value)
+// fetch gets the secret version labeled by the given stage, or the given version if versionID isn't empty,
+// and caches it as the stage. Concurrent fetches of the same secret version are coalesced into a single
+// remote call, called reports whether the remote call is made on behalf of the caller. The remote call
+// outlives the caller context, so that the other callers don't fail along with it, see FetchTimeout.
+func (a *DefaultAuthorizer) fetch(ctx context.Context, secretID, stage, versionID string) (s secret, called bool, err error) {
+	key := secretID + "/" + stage
+	if versionID != "" {
+		key = secretID + "/version/" + versionID
+	}
+	v, err, _ := a.group.Do(key, func() (interface{}, error) {
+		called = true
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.cfg.FetchTimeout)
+		defer cancel()
+
+		seq := a.janitor.nextSeq()
+		fetched, err := a.getSecret(ctx, secretID, stage, versionID)
+		if err != nil {
+			return fetched, err
+		}
+		if old, ok := a.janitor.casStage(secretID, stage, fetched, seq); ok && old.versionID != fetched.versionID {
+			// a new secret version invalidates the previously rejected values
+			a.blackList(secretID).purge()
+		}
+		return fetched, nil
+	})
+	if err != nil {
+		return secret{}, called, err
+	}
+	return v.(secret), called, nil
+}
+
+// getSecret gets the secret value of the given stage, or of the given version if versionID isn't empty, from
+// the secret source. Fetching a version requires the source to be a VersionFetcher.
+// A missing PREVIOUS or PENDING stage is not considered as an error, an empty secret is returned instead.
+func (a *DefaultAuthorizer) getSecret(ctx context.Context, secretID, stage, versionID string) (secret, error) {
+	var (
+		v   SecretValue
+		err error
+	)
+	if versionID != "" {
+		v, err = a.source.(VersionFetcher).FetchVersion(ctx, secretID, versionID)
+	} else {
+		v, err = a.source.Fetch(ctx, secretID, stage)
+	}
+	s := secret{fetchedAt: time.Now()}
+	if err != nil {
+		if errors.Is(err, ErrSecretNotFound) && stage != VersionCurrent && versionID == "" {
+			return s, nil
+		}
+		return s, classifyError(err)
+	}
+	return a.newSecret(v, s.fetchedAt)
+}
+
+// newSecret returns the cached form of the given secret version.
+func (a *DefaultAuthorizer) newSecret(v SecretValue, fetchedAt time.Time) (secret, error) {
+	s := secret{fetchedAt: fetchedAt}
+
+	var err error
+	value := v.Value
+	if a.cfg.SecretField != "" {
+		if value, err = SecretField(value, a.cfg.SecretField); err != nil {
+			return s, &sourceError{kind: ErrInvalidSecret, err: err}
+		}
+	}
+	// only the secret value digest is kept, unless signatures are enabled
+	s.hash = a.janitor.digest(value)
+	if a.cfg.Keyring {
+		kr, err := a.janitor.newKeyring(value, a.cfg.SignatureEnabled)
+		if err != nil {
+			return s, &sourceError{kind: ErrInvalidSecret, err: err}
+		}
+		s.keyring = kr
+	} else if a.cfg.SignatureEnabled {
+		s.value = value
+	}
+	s.versionID = v.VersionID
+	s.createdAt = v.CreatedAt
 
-	return ErrUnauthorized, false
+	return s, nil
 }
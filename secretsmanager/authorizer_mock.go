@@ -2,17 +2,60 @@ package secretsmanager
 
 import "context"
 
-// MockAuthorizer is a mock implementation of the Updater interface.
+// MockAuthorizer is a mock implementation of the DecisionAuthorizer, SignatureAuthorizer,
+// WebhookAuthorizer, TokenAuthorizer and KeyringAuthorizer interfaces.
 type MockAuthorizer struct {
-	AuthorizeFn func(ctx context.Context, secretID, value string) (error, bool)
+	DecideFn          func(ctx context.Context, secretID, value string) (Decision, error)
+	DecideSignatureFn func(ctx context.Context, secretID string, r SignedRequest) (Decision, error)
+	DecideWebhookFn   func(ctx context.Context, secretID string, v WebhookVerifier, r WebhookRequest) (Decision, error)
+	DecideTokenFn     func(ctx context.Context, secretID, token string) (Decision, error)
+	DecideKeyFn       func(ctx context.Context, secretID, value string, scope Scope) (Decision, error)
 }
 
-var _ Authorizer = &MockAuthorizer{}
+var (
+	_ DecisionAuthorizer  = &MockAuthorizer{}
+	_ SignatureAuthorizer = &MockAuthorizer{}
+	_ WebhookAuthorizer   = &MockAuthorizer{}
+	_ TokenAuthorizer     = &MockAuthorizer{}
+	_ KeyringAuthorizer   = &MockAuthorizer{}
+)
 
-// Update mocks the Update method.
-func (m *MockAuthorizer) Authorize(ctx context.Context, secretID, value string) (error, bool) {
-	if m.AuthorizeFn != nil {
-		return m.AuthorizeFn(ctx, secretID, value)
+// Decide mocks the Decide method.
+func (m *MockAuthorizer) Decide(ctx context.Context, secretID, value string) (Decision, error) {
+	if m.DecideFn != nil {
+		return m.DecideFn(ctx, secretID, value)
 	}
-	return nil, false
+	return Decision{Allowed: true}, nil
+}
+
+// DecideSignature mocks the DecideSignature method.
+func (m *MockAuthorizer) DecideSignature(ctx context.Context, secretID string, r SignedRequest) (Decision, error) {
+	if m.DecideSignatureFn != nil {
+		return m.DecideSignatureFn(ctx, secretID, r)
+	}
+	return Decision{Allowed: true}, nil
+}
+
+// DecideWebhook mocks the DecideWebhook method.
+func (m *MockAuthorizer) DecideWebhook(ctx context.Context, secretID string, v WebhookVerifier, r WebhookRequest) (Decision, error) {
+	if m.DecideWebhookFn != nil {
+		return m.DecideWebhookFn(ctx, secretID, v, r)
+	}
+	return Decision{Allowed: true}, nil
+}
+
+// DecideToken mocks the DecideToken method.
+func (m *MockAuthorizer) DecideToken(ctx context.Context, secretID, token string) (Decision, error) {
+	if m.DecideTokenFn != nil {
+		return m.DecideTokenFn(ctx, secretID, token)
+	}
+	return Decision{Allowed: true}, nil
+}
+
+// DecideKey mocks the DecideKey method. It falls back to DecideFn if DecideKeyFn is not set.
+func (m *MockAuthorizer) DecideKey(ctx context.Context, secretID, value string, scope Scope) (Decision, error) {
+	if m.DecideKeyFn != nil {
+		return m.DecideKeyFn(ctx, secretID, value, scope)
+	}
+	return m.Decide(ctx, secretID, value)
 }
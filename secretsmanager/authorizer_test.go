@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -11,6 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
 )
 
 func TestAuthorizer(t *testing.T) {
@@ -30,7 +32,7 @@ func TestAuthorizer(t *testing.T) {
 			ac.GracePeriod = time.Second
 		})
 
-		err, _ := auth.Authorize(ctx, secret, "")
+		_, err := auth.Decide(ctx, secret, "")
 		if want, got := ErrInvalidSecretValue, err; !errors.Is(got, want) {
 			t.Fatalf("expect %v, %v be equals", want, got)
 		}
@@ -52,7 +54,7 @@ func TestAuthorizer(t *testing.T) {
 			ac.CoolDownPeriod = time.Second
 			ac.GracePeriod = time.Second
 		})
-		err, _ := auth.Authorize(ctx, secret, value)
+		_, err := auth.Decide(ctx, secret, value)
 		if want, got := ErrAuthorizationFailed, err; !errors.Is(got, want) {
 			t.Fatalf("expect %v, %v be equals", want, got)
 		}
@@ -61,7 +63,7 @@ func TestAuthorizer(t *testing.T) {
 		}
 
 		// Make sure value is not black listed, this implies a second secret API call
-		err, _ = auth.Authorize(ctx, secret, value)
+		_, err = auth.Decide(ctx, secret, value)
 		if want, got := ErrAuthorizationFailed, err; !errors.Is(got, want) {
 			t.Fatalf("expect %v, %v be equals", want, got)
 		}
@@ -77,45 +79,55 @@ func TestAuthorizer(t *testing.T) {
 		cli := &MockClient{
 			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
 				atomic.AddInt32(&spyCalls, 1)
-				return &secretsmanager.GetSecretValueOutput{}, &types.ResourceNotFoundException{}
+				if aws.ToString(gsvi.VersionStage) != VersionCurrent {
+					return nil, &types.ResourceNotFoundException{}
+				}
+				return &secretsmanager.GetSecretValueOutput{
+					SecretString: aws.String("current_value"),
+					VersionId:    aws.String("v1"),
+				}, nil
 			},
 		}
 		auth := NewAuthorizer(cli, j, func(ac *AuthorizerConfig) {
 			ac.CoolDownPeriod = time.Second
 			ac.GracePeriod = time.Second
+			ac.BlackListTTL = ttl
 		})
 
-		err, _ := auth.Authorize(ctx, secret, value)
+		_, err := auth.Decide(ctx, secret, value)
 		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
 			t.Fatalf("expect %v, %v be equals", want, got)
 		}
 
-		// a second 'Authorize' call should not trigger secret API call,
+		// a second 'Decide' call should not trigger secret API call,
 		// the invalid value must be already in the blacklist cache
-		err, remoteCalled := auth.Authorize(ctx, secret, value)
+		d, err := auth.Decide(ctx, secret, value)
 		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
 			t.Fatalf("expect %v, %v be equals", want, got)
 		}
 		if spyCalls != 1 {
 			t.Fatal("expect 'GetSecretValue' is called once")
 		}
-		if !remoteCalled {
-			t.Fatal("expect 'remoteCalled' be true, got false")
+		if d.RemoteCalls != 0 {
+			t.Fatal("expect 'RemoteCalls' be 0, got", d.RemoteCalls)
+		}
+		if !d.BlackListed || d.Allowed || d.Reason == "" {
+			t.Fatalf("expect decision be black listed and denied, got %+v", d)
 		}
 
-		// wait until the cache is expired
+		// wait until the cache and the black list entry are expired
 		time.Sleep(ttl + 100*time.Millisecond)
 
 		// a second secret API call has to be made
-		err, remoteCalled = auth.Authorize(ctx, secret, value)
+		d, err = auth.Decide(ctx, secret, value)
 		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
 			t.Fatalf("expect %v, %v be equals", want, got)
 		}
 		if spyCalls != 2 {
 			t.Fatal("expect 'GetSecretValue' is called twice")
 		}
-		if remoteCalled {
-			t.Fatal("expect 'remoteCalled' be false, got true")
+		if d.RemoteCalls != 1 {
+			t.Fatal("expect 'RemoteCalls' be 1, got", d.RemoteCalls)
 		}
 	})
 
@@ -136,36 +148,551 @@ func TestAuthorizer(t *testing.T) {
 			ac.GracePeriod = time.Second
 		})
 
-		err, _ := auth.Authorize(ctx, secret, value)
+		_, err := auth.Decide(ctx, secret, value)
 		if err != nil {
 			t.Fatalf("expect error be nil, got %v", err)
 		}
 
-		// a second call of 'Authorize' must use the cached secret value
-		err, remoteCalled := auth.Authorize(ctx, secret, value)
+		// a second call of 'Decide' must use the cached secret value
+		d, err := auth.Decide(ctx, secret, value)
 		if err != nil {
 			t.Fatalf("expect error be nil, got %v", err)
 		}
 		if spyCalls != 1 {
 			t.Fatal("expect 'GetSecretValue' is called once", spyCalls)
 		}
-		if remoteCalled {
-			t.Fatal("expect 'remoteCalled' be false, got true")
+		if !d.Allowed || !d.Cached || d.RemoteCalls != 0 {
+			t.Fatalf("expect decision be allowed using cache, got %+v", d)
+		}
+		if want, got := VersionCurrent, d.Stage; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
 		}
 
 		// wait until the cache is expired
 		time.Sleep(ttl + 100*time.Millisecond)
 
 		// a second secret API call has to be made
-		err, remoteCalled = auth.Authorize(ctx, secret, value)
+		d, err = auth.Decide(ctx, secret, value)
 		if err != nil {
 			t.Fatalf("expect error be nil, got %v", err)
 		}
 		if spyCalls != 2 {
 			t.Fatal("expect 'GetSecretValue' is called twice")
 		}
+		if d.Cached || d.RemoteCalls != 1 {
+			t.Fatalf("expect decision be made using a remote call, got %+v", d)
+		}
+	})
+
+	t.Run("with black list purged on new secret version", func(t *testing.T) {
+		version, current := "v1", "old_value"
+		value := "new_value"
+
+		cli := &MockClient{
+			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+				if aws.ToString(gsvi.VersionStage) != VersionCurrent {
+					return nil, &types.ResourceNotFoundException{}
+				}
+				return &secretsmanager.GetSecretValueOutput{
+					SecretString: aws.String(current),
+					VersionId:    aws.String(version),
+				}, nil
+			},
+		}
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.CoolDownPeriod = 0
+			ac.GracePeriod = 0
+		})
+
+		// the new value arrives before the secret rotation
+		_, err := auth.Decide(ctx, secret, value)
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if d, _ := auth.Decide(ctx, secret, value); !d.BlackListed {
+			t.Fatal("expect value be black listed")
+		}
+
+		// the secret is rotated, and the new version is observed by a subsequent refresh
+		version, current = "v2", value
+		d, err := auth.Decide(ctx, secret, "another_value")
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if d.RemoteCalls == 0 {
+			t.Fatal("expect secret be refreshed")
+		}
+
+		d, err = auth.Decide(ctx, secret, value)
+		if err != nil {
+			t.Fatalf("expect error be nil, got %v", err)
+		}
+		if want, got := "v2", d.VersionID; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with concurrent fetches coalesced", func(t *testing.T) {
+		spyCalls := int32(0)
+		value := "valid_value"
+
+		cli := &MockClient{
+			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+				atomic.AddInt32(&spyCalls, 1)
+				// simulate a slow remote call, so that concurrent callers overlap
+				time.Sleep(50 * time.Millisecond)
+				return &secretsmanager.GetSecretValueOutput{
+					SecretString: aws.String(value),
+					CreatedDate:  aws.Time(time.Now()),
+				}, nil
+			},
+		}
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.CoolDownPeriod = time.Second
+			ac.GracePeriod = 0
+		})
+
+		var (
+			wg          sync.WaitGroup
+			remoteCalls int32
+			failures    int32
+		)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d, err := auth.Decide(ctx, secret, value)
+				if err != nil {
+					atomic.AddInt32(&failures, 1)
+				}
+				atomic.AddInt32(&remoteCalls, int32(d.RemoteCalls))
+			}()
+		}
+		wg.Wait()
+
+		if failures != 0 {
+			t.Fatalf("expect all decisions be allowed, got %d failures", failures)
+		}
+		if spyCalls != 1 {
+			t.Fatal("expect 'GetSecretValue' is called once, got", spyCalls)
+		}
+		if remoteCalls != 1 {
+			t.Fatal("expect a single decision to report the remote call, got", remoteCalls)
+		}
+	})
+
+	t.Run("with the first caller cancelled", func(t *testing.T) {
+		spyCalls := int32(0)
+		value := "valid_value"
+		started := make(chan struct{})
+
+		cli := &MockClient{
+			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+				if atomic.AddInt32(&spyCalls, 1) == 1 {
+					close(started)
+				}
+				select {
+				case <-time.After(50 * time.Millisecond):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				return &secretsmanager.GetSecretValueOutput{
+					SecretString: aws.String(value),
+					CreatedDate:  aws.Time(time.Now()),
+				}, nil
+			},
+		}
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.CoolDownPeriod = time.Second
+			ac.GracePeriod = 0
+		})
+
+		firstCtx, cancel := context.WithCancel(ctx)
+		first := make(chan error, 1)
+		go func() {
+			_, err := auth.Decide(firstCtx, secret, value)
+			first <- err
+		}()
+		<-started
+
+		waiter := make(chan error, 1)
+		go func() {
+			_, err := auth.Decide(ctx, secret, value)
+			waiter <- err
+		}()
+		// let the waiter join the shared fetch before cancelling the first caller
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+
+		if err := <-waiter; err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if err := <-first; err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := int32(1), atomic.LoadInt32(&spyCalls); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with multiple secrets", func(t *testing.T) {
+		secret1, secret2 := secret+"1", secret+"2"
+		values := map[string]string{
+			secret1: "value_1",
+			secret2: "value_2",
+		}
+
+		cli := &MockClient{
+			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+				return &secretsmanager.GetSecretValueOutput{
+					SecretString: aws.String(values[aws.ToString(gsvi.SecretId)]),
+					CreatedDate:  aws.Time(time.Now()),
+				}, nil
+			},
+		}
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.CoolDownPeriod = time.Second
+			ac.GracePeriod = 0
+		})
+
+		for id, value := range values {
+			if _, err := auth.Decide(ctx, id, value); err != nil {
+				t.Fatalf("expect error be nil, got %v", err)
+			}
+		}
+
+		// values of a secret must not authorize another one
+		_, err := auth.Decide(ctx, secret1, values[secret2])
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+
+		// black lists are isolated too
+		if d, _ := auth.Decide(ctx, secret2, values[secret2]); !d.Allowed || !d.Cached {
+			t.Fatalf("expect decision be allowed using cache, got %+v", d)
+		}
+	})
+
+	t.Run("with legacy authorizer adapter", func(t *testing.T) {
+		auth := AsAuthorizer(&MockAuthorizer{
+			DecideFn: func(ctx context.Context, secretID, value string) (Decision, error) {
+				return Decision{RemoteCalls: 2, Reason: "no matching secret version"}, ErrUnauthorized
+			},
+		})
+
+		err, remoteCalled := auth.Authorize(ctx, secret, "a_value")
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
 		if !remoteCalled {
 			t.Fatal("expect 'remoteCalled' be true, got false")
 		}
 	})
 }
+
+func BenchmarkAuthorizer(b *testing.B) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+	value := "valid_value"
+
+	cli := &MockClient{
+		GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+			// simulate a remote call latency
+			time.Sleep(time.Millisecond)
+			return &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String(value),
+				CreatedDate:  aws.Time(time.Now()),
+			}, nil
+		},
+	}
+
+	b.Run("cached", func(b *testing.B) {
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute))
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := auth.Decide(ctx, secret, value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+
+	b.Run("cold", func(b *testing.B) {
+		j := NewJanitor(time.Minute)
+		auth := NewAuthorizer(cli, j)
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				// wipe the cache to force concurrent remote calls
+				j.reset()
+				if _, err := auth.Decide(ctx, secret, value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+func TestAuthorizer_Availability(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+	value := "valid_value"
+
+	// newClient returns a client which fails with the failing error once set
+	newClient := func(failing *error) *MockClient {
+		return &MockClient{
+			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+				if *failing != nil {
+					return nil, *failing
+				}
+				if aws.ToString(gsvi.VersionStage) != VersionCurrent {
+					return nil, &types.ResourceNotFoundException{}
+				}
+				return &secretsmanager.GetSecretValueOutput{
+					SecretString: aws.String(value),
+					VersionId:    aws.String("v1"),
+				}, nil
+			},
+		}
+	}
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException"}
+
+	t.Run("with classified source errors", func(t *testing.T) {
+		tcs := []struct {
+			err  error
+			want error
+		}{
+			{err: throttled, want: ErrSourceThrottled},
+			{err: &smithy.GenericAPIError{Code: "AccessDeniedException"}, want: ErrSourceAccessDenied},
+			{err: &types.ResourceNotFoundException{}, want: ErrSecretNotFound},
+		}
+		for _, tc := range tcs {
+			failing := tc.err
+			auth := NewAuthorizer(newClient(&failing), NewJanitor(time.Minute))
+
+			_, err := auth.Decide(ctx, secret, value)
+			if want, got := tc.want, err; !errors.Is(got, want) {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := ErrAuthorizationFailed, err; !errors.Is(got, want) {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		}
+	})
+
+	t.Run("with serve stale policy", func(t *testing.T) {
+		var failing error
+		auth := NewAuthorizer(newClient(&failing), NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.CoolDownPeriod = 0
+			ac.Availability = ServeStale
+			ac.MaxStaleness = 100 * time.Millisecond
+		})
+
+		// warm up the cache
+		if _, err := auth.Decide(ctx, secret, value); err != nil {
+			t.Fatalf("expect error be nil, got %v", err)
+		}
+
+		failing = throttled
+
+		if _, err := auth.Decide(ctx, secret, value); err != nil {
+			t.Fatalf("expect error be nil, got %v", err)
+		}
+		d, err := auth.Decide(ctx, secret, "another_value")
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if !d.Stale || d.SourceErr == nil {
+			t.Fatalf("expect decision be stale, got %+v", d)
+		}
+		// stale decisions don't black list values
+		if d, _ := auth.Decide(ctx, secret, "another_value"); d.BlackListed {
+			t.Fatal("expect value be not black listed")
+		}
+
+		// wait until the cache exceeds the max staleness
+		time.Sleep(150 * time.Millisecond)
+
+		_, err = auth.Decide(ctx, secret, "another_value")
+		if want, got := ErrSourceThrottled, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with fail open policy", func(t *testing.T) {
+		var failing error = throttled
+		auth := NewAuthorizer(newClient(&failing), NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.Availability = FailOpen
+		})
+
+		d, err := auth.Decide(ctx, secret, "any_value")
+		if err != nil {
+			t.Fatalf("expect error be nil, got %v", err)
+		}
+		if !d.Allowed || !d.FailedOpen || !errors.Is(d.SourceErr, ErrSourceThrottled) {
+			t.Fatalf("expect decision be failed open, got %+v", d)
+		}
+
+		// missing secrets always fail closed
+		failing = &types.ResourceNotFoundException{}
+		_, err = auth.Decide(ctx, secret, "any_value")
+		if want, got := ErrSecretNotFound, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+}
+
+func TestAuthorizer_GraceWindow(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+
+	values := map[string]string{
+		VersionCurrent:  "current_value",
+		VersionPrevious: "previous_value",
+		VersionPending:  "pending_value",
+	}
+	versions := map[string]string{
+		VersionCurrent:  "v2",
+		VersionPrevious: "v1",
+		VersionPending:  "v3",
+	}
+
+	// versions are created long before the rotation, the default grace period is always exceeded,
+	// except the pending version which is created at the given age
+	newClient := func(desc *secretsmanager.DescribeSecretOutput, pendingAge time.Duration) *MockClient {
+		return &MockClient{
+			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+				stage := aws.ToString(gsvi.VersionStage)
+				createdAt := time.Now().Add(-time.Hour)
+				if stage == VersionPending {
+					createdAt = time.Now().Add(-pendingAge)
+				}
+				return &secretsmanager.GetSecretValueOutput{
+					SecretString: aws.String(values[stage]),
+					VersionId:    aws.String(versions[stage]),
+					CreatedDate:  aws.Time(createdAt),
+				}, nil
+			},
+			DescribeSecretFunc: func(ctx context.Context, dsi *secretsmanager.DescribeSecretInput, f ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+				return desc, nil
+			},
+		}
+	}
+
+	t.Run("with undescribed secret", func(t *testing.T) {
+		auth := NewAuthorizer(newClient(nil, time.Hour), NewJanitor(time.Minute))
+
+		_, err := auth.Decide(ctx, secret, values[VersionPrevious])
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with deployment not recorded yet", func(t *testing.T) {
+		auth := NewAuthorizer(newClient(&secretsmanager.DescribeSecretOutput{
+			LastRotatedDate: aws.Time(time.Now().Add(-time.Minute)),
+			VersionIdsToStages: map[string][]string{
+				"v2": {VersionCurrent},
+				"v1": {VersionPrevious},
+			},
+		}, time.Hour), NewJanitor(time.Minute))
+
+		d, err := auth.Decide(ctx, secret, values[VersionPrevious])
+		if err != nil {
+			t.Fatalf("expect error be nil, got %v", err)
+		}
+		if want, got := VersionPrevious, d.Stage; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		_, err = auth.Decide(ctx, secret, values[VersionPending])
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with deployment recorded", func(t *testing.T) {
+		auth := NewAuthorizer(newClient(&secretsmanager.DescribeSecretOutput{
+			LastRotatedDate: aws.Time(time.Now().Add(-2 * time.Minute)),
+			VersionIdsToStages: map[string][]string{
+				"v2": {VersionCurrent},
+				"v1": {VersionPrevious},
+			},
+			Tags: []types.Tag{
+				{Key: aws.String(TagDeployedVersion), Value: aws.String("v2")},
+				{Key: aws.String(TagDeployedAt), Value: aws.String(time.Now().Add(-time.Minute).Format(time.RFC3339))},
+			},
+		}, time.Hour), NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.GracePeriod = 2 * time.Minute
+		})
+
+		// the previous version is tolerated during the grace period following the deployment
+		if _, err := auth.Decide(ctx, secret, values[VersionPrevious]); err != nil {
+			t.Fatalf("expect error be nil, got %v", err)
+		}
+
+		auth = NewAuthorizer(newClient(&secretsmanager.DescribeSecretOutput{
+			LastRotatedDate: aws.Time(time.Now().Add(-2 * time.Minute)),
+			VersionIdsToStages: map[string][]string{
+				"v2": {VersionCurrent},
+				"v1": {VersionPrevious},
+			},
+			Tags: []types.Tag{
+				{Key: aws.String(TagDeployedVersion), Value: aws.String("v2")},
+				{Key: aws.String(TagDeployedAt), Value: aws.String(time.Now().Add(-time.Minute).Format(time.RFC3339))},
+			},
+		}, time.Hour), NewJanitor(time.Minute))
+
+		_, err := auth.Decide(ctx, secret, values[VersionPrevious])
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with ongoing rotation", func(t *testing.T) {
+		auth := NewAuthorizer(newClient(&secretsmanager.DescribeSecretOutput{
+			LastRotatedDate: aws.Time(time.Now().Add(-24 * time.Hour)),
+			VersionIdsToStages: map[string][]string{
+				"v2": {VersionCurrent},
+				"v1": {VersionPrevious},
+				"v3": {VersionPending},
+			},
+		}, time.Minute), NewJanitor(time.Minute))
+
+		d, err := auth.Decide(ctx, secret, values[VersionPending])
+		if err != nil {
+			t.Fatalf("expect error be nil, got %v", err)
+		}
+		if want, got := VersionPending, d.Stage; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		_, err = auth.Decide(ctx, secret, values[VersionPrevious])
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with deployment longer than the grace period", func(t *testing.T) {
+		// the pending version is created long before, the rotation still waits for the deployment
+		auth := NewAuthorizer(newClient(&secretsmanager.DescribeSecretOutput{
+			LastRotatedDate: aws.Time(time.Now().Add(-24 * time.Hour)),
+			VersionIdsToStages: map[string][]string{
+				"v2": {VersionCurrent},
+				"v1": {VersionPrevious},
+				"v3": {VersionPending},
+			},
+		}, time.Hour), NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.DeploymentGracePeriod = 15 * time.Minute
+		})
+
+		d, err := auth.Decide(ctx, secret, values[VersionPending])
+		if err != nil {
+			t.Fatalf("expect error be nil, got %v", err)
+		}
+		if want, got := VersionPending, d.Stage; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+}
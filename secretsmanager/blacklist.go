@@ -0,0 +1,141 @@
+package secretsmanager
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// errBlackListed is returned when inserting an entry which is already black listed.
+	errBlackListed = errors.New("already black listed")
+
+	// errBlackListFull is returned when an entry can't be inserted, i.e. the black list is either disabled
+	// or full of unexpired entries.
+	errBlackListFull = errors.New("black list full")
+)
+
+// blackList is a size-capped LRU set of rejected value digests.
+// Each entry expires after the given TTL. A zero capacity or TTL disables the black list.
+type blackList struct {
+	mu sync.Mutex
+
+	capacity int
+	ttl      time.Duration
+
+	ll    *list.List
+	items map[digest]*list.Element
+}
+
+type blackListEntry struct {
+	key       digest
+	expiresAt time.Time
+}
+
+func newBlackList(capacity int, ttl time.Duration) *blackList {
+	return &blackList{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[digest]*list.Element),
+	}
+}
+
+func (b *blackList) disabled() bool {
+	return b.capacity <= 0 || b.ttl <= 0
+}
+
+// add black lists the given digest, and evicts the least recently used entry if capacity is exceeded.
+func (b *blackList) add(h digest) {
+	if b.disabled() {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if el, ok := b.items[h]; ok {
+		el.Value.(*blackListEntry).expiresAt = time.Now().Add(b.ttl)
+		b.ll.MoveToFront(el)
+		return
+	}
+
+	b.items[h] = b.ll.PushFront(&blackListEntry{key: h, expiresAt: time.Now().Add(b.ttl)})
+
+	for b.ll.Len() > b.capacity {
+		b.remove(b.ll.Back())
+	}
+}
+
+// insert adds the given digest only if it's not already present and not yet expired.
+// Unlike add, it never evicts unexpired entries, which could be inserted again: it fails instead.
+func (b *blackList) insert(h digest) error {
+	if b.disabled() {
+		return errBlackListFull
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if el, ok := b.items[h]; ok {
+		if now.Before(el.Value.(*blackListEntry).expiresAt) {
+			return errBlackListed
+		}
+		b.remove(el)
+	}
+
+	// reclaim the expired entries, starting from the least recently used one
+	for el := b.ll.Back(); el != nil && !now.Before(el.Value.(*blackListEntry).expiresAt); el = b.ll.Back() {
+		b.remove(el)
+	}
+	if b.ll.Len() >= b.capacity {
+		return errBlackListFull
+	}
+
+	b.items[h] = b.ll.PushFront(&blackListEntry{key: h, expiresAt: now.Add(b.ttl)})
+	return nil
+}
+
+// contains reports whether the given digest is black listed and not yet expired.
+func (b *blackList) contains(h digest) bool {
+	if b.disabled() {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	el, ok := b.items[h]
+	if !ok {
+		return false
+	}
+	if time.Now().After(el.Value.(*blackListEntry).expiresAt) {
+		b.remove(el)
+		return false
+	}
+	b.ll.MoveToFront(el)
+	return true
+}
+
+// purge removes all the black list entries.
+func (b *blackList) purge() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ll.Init()
+	b.items = make(map[digest]*list.Element)
+}
+
+func (b *blackList) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.ll.Len()
+}
+
+func (b *blackList) remove(el *list.Element) {
+	b.ll.Remove(el)
+	delete(b.items, el.Value.(*blackListEntry).key)
+}
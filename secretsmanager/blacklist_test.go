@@ -0,0 +1,105 @@
+package secretsmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlackList(t *testing.T) {
+	j := NewJanitor(time.Minute)
+
+	t.Run("evict least recently used entries", func(t *testing.T) {
+		bl := newBlackList(2, time.Minute)
+
+		v1, v2, v3 := j.digest("v1"), j.digest("v2"), j.digest("v3")
+		bl.add(v1)
+		bl.add(v2)
+
+		// v1 becomes the most recently used entry
+		if !bl.contains(v1) {
+			t.Fatal("expect v1 be black listed")
+		}
+
+		bl.add(v3)
+		if want, got := 2, bl.len(); want != got {
+			t.Fatalf("expect %d, %d be equals", want, got)
+		}
+		if bl.contains(v2) {
+			t.Fatal("expect v2 be evicted")
+		}
+		if !bl.contains(v1) || !bl.contains(v3) {
+			t.Fatal("expect v1 and v3 be black listed")
+		}
+	})
+
+	t.Run("expire entries", func(t *testing.T) {
+		ttl := 50 * time.Millisecond
+		bl := newBlackList(10, ttl)
+
+		v := j.digest("v")
+		bl.add(v)
+		if !bl.contains(v) {
+			t.Fatal("expect v be black listed")
+		}
+
+		time.Sleep(ttl + 10*time.Millisecond)
+
+		if bl.contains(v) {
+			t.Fatal("expect v be expired")
+		}
+		if want, got := 0, bl.len(); want != got {
+			t.Fatalf("expect %d, %d be equals", want, got)
+		}
+	})
+
+	t.Run("purge entries", func(t *testing.T) {
+		bl := newBlackList(10, time.Minute)
+
+		bl.add(j.digest("v1"))
+		bl.add(j.digest("v2"))
+		bl.purge()
+
+		if want, got := 0, bl.len(); want != got {
+			t.Fatalf("expect %d, %d be equals", want, got)
+		}
+	})
+
+	t.Run("insert entries", func(t *testing.T) {
+		ttl := 50 * time.Millisecond
+		bl := newBlackList(1, ttl)
+
+		v1, v2 := j.digest("v1"), j.digest("v2")
+		if err := bl.insert(v1); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := errBlackListed, bl.insert(v1); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		// unexpired entries are never evicted
+		if want, got := errBlackListFull, bl.insert(v2); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+
+		time.Sleep(ttl + 10*time.Millisecond)
+
+		if err := bl.insert(v2); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if bl.contains(v1) {
+			t.Fatal("expect v1 be expired")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		bl := newBlackList(0, time.Minute)
+
+		v := j.digest("v")
+		bl.add(v)
+		if bl.contains(v) {
+			t.Fatal("expect v be not black listed")
+		}
+		if want, got := errBlackListFull, bl.insert(v); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+}
@@ -2,30 +2,62 @@ package secretsmanager
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"sync"
 	"time"
 )
 
-type BlackList map[string]struct{}
+// digest is a keyed hash of a secret value.
+// Plain secret values are never cached, only their digests are.
+type digest [sha256.Size]byte
+
+// equal compares digests in constant time
+func (d digest) equal(o digest) bool {
+	return subtle.ConstantTimeCompare(d[:], o[:]) == 1
+}
 
 type secret struct {
-	value     string
+	hash digest
+	// value is the plain secret value, it's only kept if required (see AuthorizerConfig.SignatureEnabled)
+	value string
+	// keyring holds the parsed keys if the secret is a keyring (see AuthorizerConfig.Keyring)
+	keyring   *keyring
+	versionID string
 	createdAt time.Time
+	// fetchedAt is the time the secret stage is fetched from the secret source,
+	// it's also set for missing stages.
+	fetchedAt time.Time
 }
 
 func (s secret) IsZero() bool {
-	return s.value == "" && s.createdAt.IsZero()
+	return s.hash == digest{} && s.createdAt.IsZero()
 }
 
 var (
 	zeroSecret = secret{}
 )
 
+// cacheEntry is a cached secret stage. The sequence number is used to
+// compare-and-swap the entry, so that a stale fetch can't overwrite a newer one.
+type cacheEntry struct {
+	secret
+	seq uint64
+}
+
+// Janitor caches the stages of one or many secrets, and periodically clears them.
 type Janitor struct {
-	current, previous, pending secret
-	cacheMu                    sync.RWMutex
+	// secrets maps secret IDs to their cached stages
+	secrets map[string]map[string]cacheEntry
+	seq     uint64
+	// floor is the sequence number of the last cache reset
+	floor   uint64
+	cacheMu sync.RWMutex
 
-	bl BlackList
+	// key is a per-process random key used to hash secret values
+	key []byte
 
 	interval time.Duration
 	done     chan struct{}
@@ -33,17 +65,32 @@ type Janitor struct {
 }
 
 func NewJanitor(interval time.Duration) *Janitor {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic("secretsmanager: failed to generate janitor hash key: " + err.Error())
+	}
+
 	return &Janitor{
+		secrets:  make(map[string]map[string]cacheEntry),
 		interval: interval,
 		done:     make(chan struct{}),
-		bl:       make(BlackList),
+		key:      key,
 	}
 }
 
+// digest returns the keyed hash (HMAC-SHA256) of the given value.
+func (j *Janitor) digest(value string) digest {
+	mac := hmac.New(sha256.New, j.key)
+	mac.Write([]byte(value))
+
+	var d digest
+	copy(d[:], mac.Sum(nil))
+	return d
+}
+
 func (j *Janitor) Run(ctx context.Context, onCleanup func()) {
 	cleanup := func() {
-		j.setCache(zeroSecret, zeroSecret, zeroSecret)
-		j.clearBlackList()
+		j.reset()
 		if onCleanup != nil {
 			onCleanup()
 		}
@@ -66,42 +113,83 @@ func (j *Janitor) Run(ctx context.Context, onCleanup func()) {
 	}()
 }
 
-func (j *Janitor) getCache() (cur, prev, pen secret, found bool) {
-	j.cacheMu.Lock()
-	defer j.cacheMu.Unlock()
+func (j *Janitor) getCache(secretID string) (cur, prev, pen secret, found bool) {
+	j.cacheMu.RLock()
+	defer j.cacheMu.RUnlock()
 
-	cur, prev, pen, found = j.current, j.previous, j.pending, !j.current.IsZero()
+	stages := j.secrets[secretID]
+	cur, prev, pen = stages[VersionCurrent].secret, stages[VersionPrevious].secret, stages[VersionPending].secret
+	found = !cur.IsZero()
 
 	return
 }
 
-func (j *Janitor) setCache(cur, prev, pen secret) {
+// setCache unconditionally overwrites the cached stages of the given secret.
+// Fetches started before the call can't overwrite the given values.
+func (j *Janitor) setCache(secretID string, cur, prev, pen secret) {
 	j.cacheMu.Lock()
 	defer j.cacheMu.Unlock()
 
-	j.current, j.previous, j.pending = cur, prev, pen
+	j.seq++
+	j.secrets[secretID] = map[string]cacheEntry{
+		VersionCurrent:  {secret: cur, seq: j.seq},
+		VersionPrevious: {secret: prev, seq: j.seq},
+		VersionPending:  {secret: pen, seq: j.seq},
+	}
 }
 
-func (j *Janitor) blackList(val string) {
+// reset clears the cached stages of all secrets.
+// Fetches started before the call can't update the cache.
+func (j *Janitor) reset() {
 	j.cacheMu.Lock()
 	defer j.cacheMu.Unlock()
 
-	j.bl[val] = struct{}{}
+	j.seq++
+	j.floor = j.seq
+	j.secrets = make(map[string]map[string]cacheEntry)
 }
 
-func (j *Janitor) isBlackListed(val string) bool {
+// evict clears the cached stages of the given secret.
+// Fetches started before the call can't update the cache.
+func (j *Janitor) evict(secretID string) {
+	j.setCache(secretID, zeroSecret, zeroSecret, zeroSecret)
+}
+
+// getStage returns the cached secret of the given secret stage.
+func (j *Janitor) getStage(secretID, stage string) secret {
+	j.cacheMu.RLock()
+	defer j.cacheMu.RUnlock()
+
+	return j.secrets[secretID][stage].secret
+}
+
+// nextSeq reserves a sequence number, it must be called before fetching a secret stage
+// and then used to update the cache using casStage.
+func (j *Janitor) nextSeq() uint64 {
 	j.cacheMu.Lock()
 	defer j.cacheMu.Unlock()
 
-	_, ok := j.bl[val]
-	return ok
+	j.seq++
+	return j.seq
 }
 
-func (j *Janitor) clearBlackList() {
+// casStage stores the secret of the given secret stage only if the sequence number
+// is greater than the stored one. It returns the replaced secret and whether the cache is updated.
+func (j *Janitor) casStage(secretID, stage string, s secret, seq uint64) (secret, bool) {
 	j.cacheMu.Lock()
 	defer j.cacheMu.Unlock()
 
-	j.bl = make(BlackList)
+	stages, ok := j.secrets[secretID]
+	if !ok {
+		stages = make(map[string]cacheEntry)
+		j.secrets[secretID] = stages
+	}
+	old := stages[stage]
+	if seq <= old.seq || seq <= j.floor {
+		return old.secret, false
+	}
+	stages[stage] = cacheEntry{secret: s, seq: seq}
+	return old.secret, true
 }
 
 func (j *Janitor) stop() {
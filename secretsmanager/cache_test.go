@@ -8,21 +8,22 @@ import (
 
 func TestCache(t *testing.T) {
 	ctx := context.Background()
+	secretID := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
 
 	ttl := 100 * time.Millisecond
 	j := NewJanitor(ttl)
 	j.Run(ctx, func() {})
 
-	cur, _, _, found := j.getCache()
+	cur, _, _, found := j.getCache(secretID)
 	if found {
 		t.Fatal("expect 'found' be false", cur)
 	}
 
-	cur, prev, pen := secret{value: "cur"}, secret{value: "prev"}, secret{value: "pen"}
+	cur, prev, pen := secret{hash: j.digest("cur")}, secret{hash: j.digest("prev")}, secret{hash: j.digest("pen")}
 
-	j.setCache(cur, prev, pen)
+	j.setCache(secretID, cur, prev, pen)
 
-	gcur, gprev, gpen, found := j.getCache()
+	gcur, gprev, gpen, found := j.getCache(secretID)
 
 	t.Log(gcur, gprev, gpen, found)
 	if !found {
@@ -40,11 +41,72 @@ func TestCache(t *testing.T) {
 
 	time.Sleep(ttl + 100*time.Millisecond)
 
-	cur, prev, pen, found = j.getCache()
+	cur, prev, pen, found = j.getCache(secretID)
 	if found {
 		t.Fatal("expect 'found' be false")
 	}
-	if cur.value != "" || prev.value != "" || pen.value != "" {
+	if !cur.IsZero() || !prev.IsZero() || !pen.IsZero() {
 		t.Fatalf("expect cache values be empty: %v, %v, %v", cur, prev, pen)
 	}
 }
+
+func TestCache_Digest(t *testing.T) {
+	j1, j2 := NewJanitor(time.Minute), NewJanitor(time.Minute)
+
+	if !j1.digest("value").equal(j1.digest("value")) {
+		t.Fatal("expect digests of the same value be equal")
+	}
+	if j1.digest("value").equal(j1.digest("other_value")) {
+		t.Fatal("expect digests of different values be different")
+	}
+	// each janitor uses its own random key
+	if j1.digest("value").equal(j2.digest("value")) {
+		t.Fatal("expect digests using different keys be different")
+	}
+}
+
+func TestCache_MultipleSecrets(t *testing.T) {
+	j := NewJanitor(time.Minute)
+
+	s1, s2 := secret{hash: j.digest("s1")}, secret{hash: j.digest("s2")}
+	j.setCache("secret1", s1, zeroSecret, zeroSecret)
+	j.setCache("secret2", s2, zeroSecret, zeroSecret)
+
+	if got := j.getStage("secret1", VersionCurrent); got != s1 {
+		t.Fatalf("expect %v, %v be equals", s1, got)
+	}
+	if got := j.getStage("secret2", VersionCurrent); got != s2 {
+		t.Fatalf("expect %v, %v be equals", s2, got)
+	}
+	if _, _, _, found := j.getCache("secret3"); found {
+		t.Fatal("expect 'found' be false")
+	}
+}
+
+func TestCache_CompareAndSwap(t *testing.T) {
+	j := NewJanitor(time.Minute)
+	secretID := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+
+	// a slow fetch starts before a fast one
+	slowSeq := j.nextSeq()
+	fastSeq := j.nextSeq()
+
+	fresh, stale := secret{versionID: "v2"}, secret{versionID: "v1"}
+
+	if _, ok := j.casStage(secretID, VersionCurrent, fresh, fastSeq); !ok {
+		t.Fatal("expect fast fetch be stored")
+	}
+	if old, ok := j.casStage(secretID, VersionCurrent, stale, slowSeq); ok || old != fresh {
+		t.Fatalf("expect stale fetch be ignored, got %v, %v", old, ok)
+	}
+	if got := j.getStage(secretID, VersionCurrent); got != fresh {
+		t.Fatalf("expect %v, %v be equals", fresh, got)
+	}
+
+	// fetches started before a cache reset can't overwrite it
+	seq := j.nextSeq()
+	j.reset()
+	if _, ok := j.casStage(secretID, VersionCurrent, stale, seq); ok {
+		t.Fatal("expect fetch started before reset be ignored")
+	}
+}
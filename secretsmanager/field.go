@@ -0,0 +1,98 @@
+package secretsmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidSecretField = errors.New("invalid secret field")
+)
+
+// SecretField returns the value of the given field of a JSON secret value.
+//
+// The field is either a top-level member name (i.e. 'apiKey'), or a JSON pointer (i.e. '/auth/apiKey').
+// String fields are returned unquoted, other fields are returned as raw JSON.
+func SecretField(value, field string) (string, error) {
+	doc, err := decodeSecretDocument(value)
+	if err != nil {
+		return "", err
+	}
+
+	var v interface{} = doc
+	for _, token := range fieldTokens(field) {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("%w: %s not found", ErrInvalidSecretField, field)
+		}
+		if v, ok = obj[token]; !ok {
+			return "", fmt.Errorf("%w: %s not found", ErrInvalidSecretField, field)
+		}
+	}
+
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSecretField, err)
+	}
+	return string(b), nil
+}
+
+// SetSecretField returns the given JSON secret value with the given field set to the given string.
+// The other members of the document are preserved, missing parent objects are created.
+func SetSecretField(value, field, fieldValue string) (string, error) {
+	doc, err := decodeSecretDocument(value)
+	if err != nil {
+		return "", err
+	}
+
+	tokens := fieldTokens(field)
+	obj := doc
+	for _, token := range tokens[:len(tokens)-1] {
+		child, ok := obj[token]
+		if !ok {
+			child = make(map[string]interface{})
+			obj[token] = child
+		}
+		if obj, ok = child.(map[string]interface{}); !ok {
+			return "", fmt.Errorf("%w: %s is not an object", ErrInvalidSecretField, token)
+		}
+	}
+	obj[tokens[len(tokens)-1]] = fieldValue
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSecretField, err)
+	}
+	return string(b), nil
+}
+
+// decodeSecretDocument decodes the given JSON object, numbers are kept as is.
+func decodeSecretDocument(value string) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(value)))
+	dec.UseNumber()
+
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil || doc == nil {
+		return nil, fmt.Errorf("%w: secret value is not a JSON object", ErrInvalidSecretField)
+	}
+	return doc, nil
+}
+
+// fieldTokens splits the given field into its JSON pointer reference tokens (RFC 6901).
+// A field without the leading '/' is a single member name.
+func fieldTokens(field string) []string {
+	if !strings.HasPrefix(field, "/") {
+		return []string{field}
+	}
+	tokens := strings.Split(field[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens
+}
@@ -0,0 +1,116 @@
+package secretsmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+func TestSecretField(t *testing.T) {
+	doc := `{"apiKey":"api_value","auth":{"a/b":"nested_value","port":5432}}`
+
+	type tc struct {
+		field string
+		value string
+		err   error
+	}
+
+	tcs := []tc{
+		{field: "apiKey", value: "api_value"},
+		{field: "/apiKey", value: "api_value"},
+		{field: "/auth/a~1b", value: "nested_value"},
+		{field: "/auth", value: `{"a/b":"nested_value","port":5432}`},
+		{field: "missing", err: ErrInvalidSecretField},
+		{field: "/apiKey/child", err: ErrInvalidSecretField},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.field, func(t *testing.T) {
+			value, err := SecretField(doc, tc.field)
+			if tc.err != nil {
+				if want, got := tc.err, err; !errors.Is(got, want) {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+			if want, got := tc.value, value; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		})
+	}
+
+	if _, err := SecretField("plain_value", "apiKey"); !errors.Is(err, ErrInvalidSecretField) {
+		t.Fatalf("expect %v, %v be equals", ErrInvalidSecretField, err)
+	}
+}
+
+func TestSetSecretField(t *testing.T) {
+	doc := `{"apiKey":"api_value","auth":{"port":5432},"user":"admin"}`
+
+	updated, err := SetSecretField(doc, "/auth/key", "new_value")
+	if err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+	if want, got := `{"apiKey":"api_value","auth":{"key":"new_value","port":5432},"user":"admin"}`, updated; want != got {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+
+	if _, err := SetSecretField(doc, "/user/key", "new_value"); !errors.Is(err, ErrInvalidSecretField) {
+		t.Fatalf("expect %v, %v be equals", ErrInvalidSecretField, err)
+	}
+}
+
+func TestAuthorizer_WithSecretField(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+
+	cli := &MockClient{
+		GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+			if aws.ToString(gsvi.VersionStage) != VersionCurrent {
+				return nil, ErrSecretNotFound
+			}
+			return &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String(`{"apiKey":"api_value","password":"db_value"}`),
+				VersionId:    aws.String("current_id"),
+				CreatedDate:  aws.Time(time.Now().Add(-time.Hour)),
+			}, nil
+		},
+	}
+
+	auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+		ac.SecretField = "apiKey"
+	})
+
+	if _, err := auth.Decide(ctx, secret, "api_value"); err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+	if _, err := auth.Decide(ctx, secret, "db_value"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expect %v, %v be equals", ErrUnauthorized, err)
+	}
+
+	auth = NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+		ac.SecretField = "missing"
+	})
+	if _, err := auth.Decide(ctx, secret, "api_value"); !errors.Is(err, ErrInvalidSecretField) {
+		t.Fatalf("expect %v, %v be equals", ErrInvalidSecretField, err)
+	}
+	// a missing field isn't a source outage, it fails closed whatever the availability policy is
+	auth = NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+		ac.SecretField = "missing"
+		ac.Availability = FailOpen
+	})
+	d, err := auth.Decide(ctx, secret, "any_value")
+	if !errors.Is(err, ErrInvalidSecret) || !errors.Is(err, ErrInvalidSecretField) {
+		t.Fatalf("expect %v, %v be equals", ErrInvalidSecretField, err)
+	}
+	if d.Allowed || d.FailedOpen {
+		t.Fatalf("expect decision be denied, got %+v", d)
+	}
+}
@@ -0,0 +1,220 @@
+package secretsmanager
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+var (
+	ErrInvalidGenerator   = errors.New("invalid secret generator")
+	ErrInvalidHeaderValue = errors.New("invalid header value")
+)
+
+// TagGenerator is the secret tag holding the generator spec of the secret (see ParseGenerator).
+// It overrides the generator configured in the rotation lambda.
+const TagGenerator = "secure-lambda-url:generator"
+
+// Generator presents a service that generates new secret values.
+type Generator interface {
+	Generate(ctx context.Context) (string, error)
+}
+
+// Alphabets of the RandGenerator.
+const (
+	AlphabetBase64URL    = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	AlphabetHex          = "0123456789abcdef"
+	AlphabetAlphanumeric = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// RandGenerator generates values of uniformly random characters of the alphabet using crypto/rand.
+type RandGenerator struct {
+	Length   int
+	Alphabet string
+}
+
+var _ Generator = RandGenerator{}
+
+// Generate implements Generator.
+func (g RandGenerator) Generate(ctx context.Context) (string, error) {
+	if g.Length <= 0 || len(g.Alphabet) < 2 {
+		return "", fmt.Errorf("%w: length and alphabet are required", ErrInvalidGenerator)
+	}
+	max := big.NewInt(int64(len(g.Alphabet)))
+	b := make([]byte, g.Length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = g.Alphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// SecretsManagerGenerator generates values using the secretsmanager GetRandomPassword API.
+type SecretsManagerGenerator struct {
+	client ClientAPI
+
+	Length                  int64
+	ExcludeCharacters       string
+	ExcludeLowercase        bool
+	ExcludeNumbers          bool
+	ExcludePunctuation      bool
+	ExcludeUppercase        bool
+	IncludeSpace            bool
+	RequireEachIncludedType bool
+}
+
+var _ Generator = &SecretsManagerGenerator{}
+
+// NewSecretsManagerGenerator returns a generator of 64 characters values, including punctuation.
+func NewSecretsManagerGenerator(cli ClientAPI, opts ...func(*SecretsManagerGenerator)) *SecretsManagerGenerator {
+	g := &SecretsManagerGenerator{
+		client:                  cli,
+		Length:                  64,
+		RequireEachIncludedType: true,
+	}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		opt(g)
+	}
+	return g
+}
+
+// Generate implements Generator.
+func (g *SecretsManagerGenerator) Generate(ctx context.Context) (string, error) {
+	in := &secretsmanager.GetRandomPasswordInput{
+		ExcludeLowercase:        aws.Bool(g.ExcludeLowercase),
+		ExcludeNumbers:          aws.Bool(g.ExcludeNumbers),
+		ExcludePunctuation:      aws.Bool(g.ExcludePunctuation),
+		ExcludeUppercase:        aws.Bool(g.ExcludeUppercase),
+		IncludeSpace:            aws.Bool(g.IncludeSpace),
+		PasswordLength:          aws.Int64(g.Length),
+		RequireEachIncludedType: aws.Bool(g.RequireEachIncludedType),
+	}
+	if g.ExcludeCharacters != "" {
+		in.ExcludeCharacters = aws.String(g.ExcludeCharacters)
+	}
+	out, err := g.client.GetRandomPassword(ctx, in)
+	if err != nil {
+		return "", err
+	}
+	if out == nil {
+		return "", nil
+	}
+	return aws.ToString(out.RandomPassword), nil
+}
+
+// ParseGenerator returns the generator of the given spec: space separated 'option=value' pairs.
+// The 'type' option is either 'secretsmanager' (default) or 'rand':
+//
+//	type=rand alphabet=base64url length=48
+//	type=secretsmanager length=64 exclude-characters=/+@ include-space=false
+//
+// The 'rand' generator options are 'alphabet' (base64url, hex or alphanumeric) and 'length' (default to 64).
+// The 'secretsmanager' generator options are the GetRandomPassword parameters: 'length', 'exclude-characters',
+// 'exclude-lowercase', 'exclude-numbers', 'exclude-punctuation', 'exclude-uppercase', 'include-space'
+// and 'require-each-included-type'.
+// The spec only contains characters allowed in secret tag values, as long as the excluded characters do.
+func ParseGenerator(cli ClientAPI, spec string) (Generator, error) {
+	opts := map[string]string{}
+	for _, field := range strings.Fields(spec) {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidGenerator, field)
+		}
+		opts[k] = v
+	}
+
+	length := func(def int64) (int64, error) {
+		v, ok := opts["length"]
+		if !ok {
+			return def, nil
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%w: invalid length %s", ErrInvalidGenerator, v)
+		}
+		return n, nil
+	}
+
+	switch opts["type"] {
+	case "rand":
+		n, err := length(64)
+		if err != nil {
+			return nil, err
+		}
+		g := RandGenerator{Length: int(n), Alphabet: AlphabetBase64URL}
+		switch opts["alphabet"] {
+		case "", "base64url":
+		case "hex":
+			g.Alphabet = AlphabetHex
+		case "alphanumeric":
+			g.Alphabet = AlphabetAlphanumeric
+		default:
+			return nil, fmt.Errorf("%w: unknown alphabet %s", ErrInvalidGenerator, opts["alphabet"])
+		}
+		return g, nil
+
+	case "", "secretsmanager":
+		g := NewSecretsManagerGenerator(cli)
+		n, err := length(g.Length)
+		if err != nil {
+			return nil, err
+		}
+		g.Length = n
+		g.ExcludeCharacters = opts["exclude-characters"]
+		for name, flag := range map[string]*bool{
+			"exclude-lowercase":          &g.ExcludeLowercase,
+			"exclude-numbers":            &g.ExcludeNumbers,
+			"exclude-punctuation":        &g.ExcludePunctuation,
+			"exclude-uppercase":          &g.ExcludeUppercase,
+			"include-space":              &g.IncludeSpace,
+			"require-each-included-type": &g.RequireEachIncludedType,
+		} {
+			v, ok := opts[name]
+			if !ok {
+				continue
+			}
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid %s %s", ErrInvalidGenerator, name, v)
+			}
+			*flag = b
+		}
+		return g, nil
+
+	default:
+		return nil, fmt.Errorf("%w: unknown type %s", ErrInvalidGenerator, opts["type"])
+	}
+}
+
+// maxHeaderValueLength is the maximum length of a CloudFront origin custom header value.
+const maxHeaderValueLength = 1783
+
+// ValidateHeaderValue checks whether the given value is a legal CloudFront origin custom header value:
+// visible ASCII characters and inner spaces, up to 1783 characters.
+func ValidateHeaderValue(value string) error {
+	if value == "" || len(value) > maxHeaderValueLength {
+		return fmt.Errorf("%w: length must be between 1 and %d", ErrInvalidHeaderValue, maxHeaderValueLength)
+	}
+	if strings.TrimSpace(value) != value {
+		return fmt.Errorf("%w: leading or trailing whitespace", ErrInvalidHeaderValue)
+	}
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c != ' ' && (c < 0x21 || c > 0x7e) {
+			return fmt.Errorf("%w: illegal character at %d", ErrInvalidHeaderValue, i)
+		}
+	}
+	return nil
+}
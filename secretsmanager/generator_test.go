@@ -0,0 +1,134 @@
+package secretsmanager
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+func TestParseGenerator(t *testing.T) {
+	ctx := context.Background()
+
+	var input *secretsmanager.GetRandomPasswordInput
+	cli := &MockClient{
+		GetRandomPasswordFunc: func(ctx context.Context, grpi *secretsmanager.GetRandomPasswordInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetRandomPasswordOutput, error) {
+			input = grpi
+			return &secretsmanager.GetRandomPasswordOutput{RandomPassword: aws.String("random")}, nil
+		},
+	}
+
+	t.Run("rand generator", func(t *testing.T) {
+		for spec, alphabet := range map[string]string{
+			"type=rand length=48":                       AlphabetBase64URL,
+			"type=rand alphabet=hex length=48":          AlphabetHex,
+			"type=rand alphabet=alphanumeric length=48": AlphabetAlphanumeric,
+		} {
+			g, err := ParseGenerator(cli, spec)
+			if err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+			value, err := g.Generate(ctx)
+			if err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+			if want, got := 48, len(value); want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if strings.Trim(value, alphabet) != "" {
+				t.Fatalf("expect %s be made of %s", value, alphabet)
+			}
+		}
+	})
+
+	t.Run("secretsmanager generator", func(t *testing.T) {
+		g, err := ParseGenerator(cli, "length=32 exclude-characters=/+@ exclude-punctuation=true")
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if _, err := g.Generate(ctx); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := int64(32), aws.ToInt64(input.PasswordLength); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if want, got := "/+@", aws.ToString(input.ExcludeCharacters); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if !aws.ToBool(input.ExcludePunctuation) || !aws.ToBool(input.RequireEachIncludedType) {
+			t.Fatal("expect punctuation be excluded and each included type be required")
+		}
+	})
+
+	for _, spec := range []string{"type=other", "type=rand alphabet=other", "length=-1", "exclude-numbers=maybe", "length"} {
+		if _, err := ParseGenerator(cli, spec); !errors.Is(err, ErrInvalidGenerator) {
+			t.Fatalf("expect %v, %v be equals", ErrInvalidGenerator, err)
+		}
+	}
+}
+
+func TestValidateHeaderValue(t *testing.T) {
+	valids := []string{"abc-DEF_123", "a!b#c$%&'*+.^`|~", "inner space"}
+	for _, v := range valids {
+		if err := ValidateHeaderValue(v); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+	}
+
+	invalids := []string{"", " leading", "trailing ", "new\nline", "café", strings.Repeat("a", 1784)}
+	for _, v := range invalids {
+		if err := ValidateHeaderValue(v); !errors.Is(err, ErrInvalidHeaderValue) {
+			t.Fatalf("expect %v, %v be equals", ErrInvalidHeaderValue, err)
+		}
+	}
+}
+
+func TestRotator_CreateWithGenerator(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+	token := "arn:aws:secretsmanager:eu-west-1:19cx3122:token/fake"
+
+	st := newRotationState("current_value", token, "")
+	cli := st.client("")
+	put := func() string { return aws.ToString(st.versions[token].value) }
+
+	rotator := NewDefaultRotator(cli, func(rc *RotatorConfig) {
+		rc.Generator = RandGenerator{Length: 16, Alphabet: AlphabetHex}
+		rc.HeaderSafe = true
+	})
+
+	t.Run("with configured generator", func(t *testing.T) {
+		if err := rotator.Create(ctx, secret, token); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if len(put()) != 16 || strings.Trim(put(), AlphabetHex) != "" {
+			t.Fatalf("expect %s be a 16 hex characters value", put())
+		}
+	})
+
+	t.Run("with generator tag", func(t *testing.T) {
+		st.versions[token].value = nil
+		st.tags = []types.Tag{{Key: aws.String(TagGenerator), Value: aws.String("type=rand alphabet=alphanumeric length=24")}}
+		if err := rotator.Create(ctx, secret, token); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := 24, len(put()); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with header unsafe value", func(t *testing.T) {
+		st.versions[token].value, st.tags = nil, nil
+		rotator := NewDefaultRotator(cli, func(rc *RotatorConfig) {
+			rc.Generator = RandGenerator{Length: 16, Alphabet: "\n\t"}
+			rc.HeaderSafe = true
+		})
+		if err := rotator.Create(ctx, secret, token); !errors.Is(err, ErrInvalidHeaderValue) {
+			t.Fatalf("expect %v, %v be equals", ErrInvalidHeaderValue, err)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package secretsmanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"strings"
+)
+
+// Self-validating keys are formatted as '<prefix>_<version hint>_<random><checksum>':
+//
+//	slu_3f2a9c1d_4Hq9...Zt1b0X3k
+//
+// The version hint is derived from the secret version ID (see VersionHint), the random part is alphanumeric,
+// and the checksum is the base62 encoded CRC32 of everything before it. The checksum isn't a secret,
+// it only allows to reject mistyped or random values without calling the secret source.
+const (
+	KeyPrefix = "slu"
+
+	keyHintLen     = 8
+	keyChecksumLen = 6
+	base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// VersionHint returns the short identifier of the given secret version embedded in formatted keys.
+func VersionHint(versionID string) string {
+	h := sha256.Sum256([]byte(versionID))
+	return hex.EncodeToString(h[:])[:keyHintLen]
+}
+
+// FormatKey returns the self-validating key of the given secret version and alphanumeric random value.
+func FormatKey(versionID, random string) string {
+	s := KeyPrefix + "_" + VersionHint(versionID) + "_" + random
+	return s + keyChecksum(s)
+}
+
+// ParseKey checks the format and the checksum of the given key, and returns its version hint.
+func ParseKey(key string) (hint string, ok bool) {
+	if !isFormattedKey(key) {
+		return "", false
+	}
+	rest := key[len(KeyPrefix)+1:]
+	if len(rest) <= keyHintLen+1+keyChecksumLen || rest[keyHintLen] != '_' {
+		return "", false
+	}
+	hint = rest[:keyHintLen]
+	if _, err := hex.DecodeString(hint); err != nil {
+		return "", false
+	}
+	random := rest[keyHintLen+1 : len(rest)-keyChecksumLen]
+	for _, c := range random {
+		if !strings.ContainsRune(base62Alphabet, c) {
+			return "", false
+		}
+	}
+	if keyChecksum(key[:len(key)-keyChecksumLen]) != key[len(key)-keyChecksumLen:] {
+		return "", false
+	}
+	return hint, true
+}
+
+// isFormattedKey reports whether the given value claims to be a formatted key, regardless of its validity.
+func isFormattedKey(value string) bool {
+	return strings.HasPrefix(value, KeyPrefix+"_")
+}
+
+// keyChecksum returns the fixed width base62 encoded CRC32 of the given string.
+func keyChecksum(s string) string {
+	n := crc32.ChecksumIEEE([]byte(s))
+	b := make([]byte, keyChecksumLen)
+	for i := keyChecksumLen - 1; i >= 0; i-- {
+		b[i] = base62Alphabet[n%62]
+		n /= 62
+	}
+	return string(b)
+}
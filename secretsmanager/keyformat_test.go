@@ -0,0 +1,111 @@
+package secretsmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+func TestParseKey(t *testing.T) {
+	versionID := "a1b2c3d4-5678-90ab-cdef-EXAMPLE11111"
+	key := FormatKey(versionID, "4Hq9mZt1b0X3kR7uWc2yPn8sLd5vGe6j")
+
+	hint, ok := ParseKey(key)
+	if !ok {
+		t.Fatalf("expect key %s be valid", key)
+	}
+	if want, got := VersionHint(versionID), hint; want != got {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+
+	invalids := []string{
+		"",
+		"legacy_value",
+		"slu_",
+		key[:len(key)-1] + "x",
+		key[:len(key)-10] + "-" + key[len(key)-9:],
+		"slu_zzzzzzzz_4Hq9mZt1b0X3kR7uWc2yPn8sLd5vGe6j000000",
+	}
+	for _, k := range invalids {
+		if _, ok := ParseKey(k); ok {
+			t.Fatalf("expect key %s be invalid", k)
+		}
+	}
+}
+
+func TestAuthorizer_WithKeyFormat(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+
+	key := FormatKey("current_id", "4Hq9mZt1b0X3kR7uWc2yPn8sLd5vGe6j")
+	values := map[string]string{
+		VersionCurrent:  key,
+		VersionPrevious: "legacy_value",
+	}
+	calls := 0
+	cli := &MockClient{
+		GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+			calls++
+			stage := aws.ToString(gsvi.VersionStage)
+			if _, ok := values[stage]; !ok {
+				return nil, ErrSecretNotFound
+			}
+			return &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String(values[stage]),
+				VersionId:    aws.String(stage + "_id"),
+				CreatedDate:  aws.Time(time.Now()),
+			}, nil
+		},
+	}
+
+	t.Run("reject malformed keys locally", func(t *testing.T) {
+		calls = 0
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.KeyFormat = true
+		})
+
+		for _, value := range []string{"legacy_value", key[:len(key)-1] + "x"} {
+			d, err := auth.Decide(ctx, secret, value)
+			if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := "malformed key", d.Reason; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if auth.blackList(secret).contains(auth.janitor.digest(value)) {
+				t.Fatalf("expect %s not be black listed", value)
+			}
+		}
+		if want, got := 0, calls; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+
+		if _, err := auth.Decide(ctx, secret, key); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+	})
+
+	t.Run("accept legacy keys", func(t *testing.T) {
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.KeyFormat = true
+			ac.LegacyKeys = true
+		})
+
+		d, err := auth.Decide(ctx, secret, "legacy_value")
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := VersionPrevious, d.Stage; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+
+		// values claiming to be formatted keys are still checked locally
+		if _, err := auth.Decide(ctx, secret, key[:len(key)-1]+"x"); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("expect %v, %v be equals", ErrUnauthorized, err)
+		}
+	})
+}
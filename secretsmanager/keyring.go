@@ -0,0 +1,247 @@
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidKeyring = errors.New("invalid keyring")
+)
+
+// KeyringAuthorizer presents a service that checks whether a value matches one of the keys
+// of a keyring secret, within the given request scope, and describes how the decision was made.
+// The returned error is nil only if the value is authorized.
+type KeyringAuthorizer interface {
+	DecideKey(ctx context.Context, secretID, value string, scope Scope) (Decision, error)
+}
+
+// Scope describes the request authorized by a keyring key.
+type Scope struct {
+	Method string
+	Path   string
+}
+
+// Keyring is a secret value holding many named keys, encoded as JSON:
+//
+//	{"keys": {"partner-a": {"value": "...", "notAfter": "2024-01-01T00:00:00Z", "paths": ["/api"], "methods": ["GET"]}}}
+type Keyring struct {
+	Keys map[string]KeyringKey `json:"keys"`
+}
+
+// KeyringKey is a named key of a keyring.
+type KeyringKey struct {
+	Value string `json:"value"`
+
+	// NotAfter is the optional expiration time of the key.
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+
+	// Paths are the optional path prefixes the key is allowed to access.
+	Paths []string `json:"paths,omitempty"`
+
+	// Methods are the optional HTTP methods the key is allowed to use.
+	Methods []string `json:"methods,omitempty"`
+}
+
+// ParseKeyring decodes the given secret value into a keyring. Keys must have a value.
+func ParseKeyring(value string) (Keyring, error) {
+	var k Keyring
+	if err := json.Unmarshal([]byte(value), &k); err != nil {
+		return Keyring{}, fmt.Errorf("%w: %v", ErrInvalidKeyring, err)
+	}
+	for name, key := range k.Keys {
+		if key.Value == "" {
+			return Keyring{}, fmt.Errorf("%w: key %s has no value", ErrInvalidKeyring, name)
+		}
+	}
+	return k, nil
+}
+
+// String encodes the keyring into a secret value.
+func (k Keyring) String() string {
+	b, _ := json.Marshal(k)
+	return string(b)
+}
+
+// keyring is the cached form of a Keyring. Only the key value digests are kept,
+// unless signatures are enabled.
+type keyring struct {
+	keys []keyringEntry
+}
+
+type keyringEntry struct {
+	name     string
+	hash     digest
+	value    string
+	notAfter time.Time
+	paths    []string
+	methods  []string
+}
+
+// PathHasPrefix reports whether the given path is either the prefix or one of its sub paths, i.e. '/api' matches
+// '/api' and '/api/orders' but neither '/apiadmin' nor '/api/../admin': the path is cleaned first.
+func PathHasPrefix(p, prefix string) bool {
+	p = path.Clean(p)
+	return p == prefix || strings.HasPrefix(p, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// allows reports whether the key is valid within the given scope, otherwise it returns the denial reason.
+// An empty scope is denied by keys restricted to paths or methods, a nil scope only checks the key expiration.
+func (e keyringEntry) allows(scope *Scope) (string, bool) {
+	if !e.notAfter.IsZero() && time.Now().After(e.notAfter) {
+		return "expired key", false
+	}
+	if scope == nil {
+		return "", true
+	}
+	if len(e.paths) > 0 {
+		allowed := false
+		for _, p := range e.paths {
+			if PathHasPrefix(scope.Path, p) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "path not allowed for key", false
+		}
+	}
+	if len(e.methods) > 0 {
+		allowed := false
+		for _, m := range e.methods {
+			if strings.EqualFold(scope.Method, m) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "method not allowed for key", false
+		}
+	}
+	return "", true
+}
+
+// newKeyring parses the given keyring secret value into its cached form.
+// The plain key values are only kept if keepValues is set.
+func (j *Janitor) newKeyring(value string, keepValues bool) (*keyring, error) {
+	k, err := ParseKeyring(value)
+	if err != nil {
+		return nil, err
+	}
+	kr := &keyring{keys: make([]keyringEntry, 0, len(k.Keys))}
+	for name, key := range k.Keys {
+		e := keyringEntry{
+			name:    name,
+			hash:    j.digest(key.Value),
+			paths:   key.Paths,
+			methods: key.Methods,
+		}
+		if keepValues {
+			e.value = key.Value
+		}
+		if key.NotAfter != nil {
+			e.notAfter = *key.NotAfter
+		}
+		kr.keys = append(kr.keys, e)
+	}
+	return kr, nil
+}
+
+// matchValue reports whether the plain value of the given secret satisfies the match function.
+// For keyring secrets, each key value allowed within the given scope is checked, and the name of the matching key
+// is returned. The reason of the denial is returned instead if a matching key isn't allowed.
+func (s secret) matchValue(scope *Scope, match func(value string) bool) (name, denied string, ok bool) {
+	if s.keyring == nil {
+		return "", "", s.value != "" && match(s.value)
+	}
+	for _, e := range s.keyring.keys {
+		if e.value == "" || !match(e.value) {
+			continue
+		}
+		if reason, allowed := e.allows(scope); !allowed {
+			denied = reason
+			continue
+		}
+		return e.name, "", true
+	}
+	return "", denied, false
+}
+
+// DecideKey implements KeyringAuthorizer.
+//
+// If the secret isn't a keyring (see AuthorizerConfig.Keyring), it behaves as Decide and the scope is ignored.
+// Otherwise, the value must match a key that is not expired and allowed within the given scope,
+// the key name is returned in the decision. Values matching a key out of scope are not black listed.
+func (a *DefaultAuthorizer) DecideKey(ctx context.Context, secretID, value string, scope Scope) (d Decision, err error) {
+	start := time.Now()
+	defer func() {
+		d.Allowed = err == nil
+		d.Latency = time.Since(start)
+	}()
+
+	if value == "" {
+		d.Reason = "empty value"
+		return d, ErrInvalidSecretValue
+	}
+	hint := ""
+	if a.cfg.KeyFormat {
+		var ok bool
+		if hint, ok = ParseKey(value); !ok && (isFormattedKey(value) || !a.cfg.LegacyKeys) {
+			d.Reason = "malformed key"
+			return d, ErrUnauthorized
+		}
+	}
+	// The plain value is only used to compute its digest
+	h := a.janitor.digest(value)
+	if a.blackList(secretID).contains(h) {
+		d.BlackListed = true
+		d.Reason = "black listed value"
+		return d, ErrUnauthorized
+	}
+
+	// keys denied by scope are not black listed, keyring decisions are throttled instead
+	denied := ""
+	match := func(s secret) bool {
+		if s.keyring == nil {
+			return s.hash.equal(h)
+		}
+		for _, e := range s.keyring.keys {
+			if !e.hash.equal(h) {
+				continue
+			}
+			if reason, ok := e.allows(&scope); !ok {
+				denied = reason
+				return false
+			}
+			d.KeyName = e.name
+			return true
+		}
+		return false
+	}
+	// keyring keys keep the hint of the version they were rotated in, not the one holding them
+	if a.cfg.VersionAddressed && hint != "" && !a.cfg.Keyring {
+		var reason string
+		addressed := func(versionID string) bool { return VersionHint(versionID) == hint }
+		if reason, err = a.decideVersion(ctx, secretID, addressed, false, &d, match); reason != "" {
+			denied = reason
+		}
+	} else {
+		err = a.decide(ctx, secretID, &d, a.cfg.Keyring, match)
+	}
+	if errors.Is(err, ErrUnauthorized) {
+		if denied != "" {
+			d.Reason = denied
+			return d, err
+		}
+		if !d.Stale {
+			// stale values may miss a newer secret version, the value is not black listed
+			a.blackList(secretID).add(h)
+		}
+	}
+	return d, err
+}
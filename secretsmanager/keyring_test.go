@@ -0,0 +1,213 @@
+package secretsmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+func TestParseKeyring(t *testing.T) {
+	k, err := ParseKeyring(`{"keys":{"partner":{"value":"partner_value","notAfter":"2023-08-01T10:00:00Z","paths":["/api"],"methods":["GET"]}}}`)
+	if err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+	key := k.Keys["partner"]
+	if want, got := "partner_value", key.Value; want != got {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+	if want, got := time.Date(2023, 8, 1, 10, 0, 0, 0, time.UTC), key.NotAfter; got == nil || !want.Equal(*got) {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+
+	for _, value := range []string{"plain_value", `{"keys":{"partner":{}}}`} {
+		if _, err := ParseKeyring(value); !errors.Is(err, ErrInvalidKeyring) {
+			t.Fatalf("expect %v, %v be equals", ErrInvalidKeyring, err)
+		}
+	}
+}
+
+func TestPathHasPrefix(t *testing.T) {
+	tcs := []struct {
+		path, prefix string
+		ok           bool
+	}{
+		{"/api", "/api", true},
+		{"/api/orders", "/api", true},
+		{"/api/orders", "/api/", true},
+		{"/api/", "/api", true},
+		{"/anything", "/", true},
+		{"/apiadmin", "/api", false},
+		{"/api/../admin", "/api", false},
+		{"/api/./orders", "/api", true},
+		{"", "/api", false},
+	}
+	for _, tc := range tcs {
+		if want, got := tc.ok, PathHasPrefix(tc.path, tc.prefix); want != got {
+			t.Fatalf("expect %v, %v be equals: %s %s", want, got, tc.path, tc.prefix)
+		}
+	}
+}
+
+func TestAuthorizer_DecideKey(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+
+	value := `{"keys":{
+		"cloudfront": {"value": "cloudfront_value"},
+		"partner": {"value": "partner_value", "paths": ["/api/"], "methods": ["GET"]},
+		"legacy": {"value": "legacy_value", "notAfter": "2020-01-01T00:00:00Z"}
+	}}`
+	cli := &MockClient{
+		GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+			if aws.ToString(gsvi.VersionStage) != VersionCurrent {
+				return nil, ErrSecretNotFound
+			}
+			return &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String(value),
+				VersionId:    aws.String("current_id"),
+				CreatedDate:  aws.Time(time.Now().Add(-time.Hour)),
+			}, nil
+		},
+	}
+
+	auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+		ac.Keyring = true
+	})
+
+	type tc struct {
+		name   string
+		value  string
+		scope  Scope
+		err    error
+		key    string
+		reason string
+	}
+
+	tcs := []tc{
+		{
+			name:  "unrestricted key",
+			value: "cloudfront_value",
+			key:   "cloudfront",
+		},
+		{
+			name:  "key within scope",
+			value: "partner_value",
+			scope: Scope{Method: "get", Path: "/api/orders"},
+			key:   "partner",
+		},
+		{
+			name:   "key out of path scope",
+			value:  "partner_value",
+			scope:  Scope{Method: "GET", Path: "/admin"},
+			err:    ErrUnauthorized,
+			reason: "path not allowed for key",
+		},
+		{
+			name:   "key out of path boundary",
+			value:  "partner_value",
+			scope:  Scope{Method: "GET", Path: "/apiadmin"},
+			err:    ErrUnauthorized,
+			reason: "path not allowed for key",
+		},
+		{
+			name:   "key out of path scope using dot segments",
+			value:  "partner_value",
+			scope:  Scope{Method: "GET", Path: "/api/../admin"},
+			err:    ErrUnauthorized,
+			reason: "path not allowed for key",
+		},
+		{
+			name:   "key out of method scope",
+			value:  "partner_value",
+			scope:  Scope{Method: "POST", Path: "/api/orders"},
+			err:    ErrUnauthorized,
+			reason: "method not allowed for key",
+		},
+		{
+			name:   "expired key",
+			value:  "legacy_value",
+			err:    ErrUnauthorized,
+			reason: "expired key",
+		},
+		{
+			name:   "unknown value",
+			value:  "invalid_value",
+			err:    ErrUnauthorized,
+			reason: "no matching secret version",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := auth.DecideKey(ctx, secret, tc.value, tc.scope)
+			if tc.err != nil {
+				if want, got := tc.err, err; !errors.Is(got, want) {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+				if want, got := tc.reason, d.Reason; want != got {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+			if want, got := tc.key, d.KeyName; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		})
+	}
+
+	t.Run("key out of scope is not black listed", func(t *testing.T) {
+		if _, err := auth.DecideKey(ctx, secret, "partner_value", Scope{}); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("expect %v, %v be equals", ErrUnauthorized, err)
+		}
+		d, err := auth.DecideKey(ctx, secret, "partner_value", Scope{Method: "GET", Path: "/api/orders"})
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if d.BlackListed {
+			t.Fatal("expect value not be black listed")
+		}
+	})
+
+	t.Run("decide denies restricted keys", func(t *testing.T) {
+		if _, err := auth.Decide(ctx, secret, "partner_value"); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("expect %v, %v be equals", ErrUnauthorized, err)
+		}
+		d, err := auth.Decide(ctx, secret, "cloudfront_value")
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := "cloudfront", d.KeyName; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with invalid keyring", func(t *testing.T) {
+		value = "plain_value"
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.Keyring = true
+		})
+		if _, err := auth.Decide(ctx, secret, "plain_value"); !errors.Is(err, ErrInvalidKeyring) {
+			t.Fatalf("expect %v, %v be equals", ErrInvalidKeyring, err)
+		}
+
+		// a malformed keyring isn't a source outage, it fails closed whatever the availability policy is
+		auth = NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.Keyring = true
+			ac.Availability = FailOpen
+		})
+		d, err := auth.Decide(ctx, secret, "any_value")
+		if !errors.Is(err, ErrInvalidSecret) || !errors.Is(err, ErrInvalidKeyring) {
+			t.Fatalf("expect %v, %v be equals", ErrInvalidSecret, err)
+		}
+		if d.Allowed || d.FailedOpen {
+			t.Fatalf("expect decision be denied, got %+v", d)
+		}
+	})
+}
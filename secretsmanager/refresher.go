@@ -0,0 +1,137 @@
+package secretsmanager
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+type RefresherConfig struct {
+	// RefreshInterval is the period after which the cached stages of a secret expire.
+	// Secrets are refreshed ahead of expiry, by a random part of the jitter.
+	RefreshInterval time.Duration
+
+	// Jitter is the maximum period by which a refresh is brought forward. It spreads the remote calls.
+	Jitter time.Duration
+
+	// RetryInterval is the period between two attempts after a failed refresh.
+	RetryInterval time.Duration
+
+	// PollInterval is the period between two rotation checks. It requires the secret source
+	// to be a SecretDescriber. Zero disables the rotation checks.
+	PollInterval time.Duration
+}
+
+// Refresher keeps the cached stages of a set of secrets up to date in background,
+// so that requests don't wait on the secret source.
+//
+// Expired secrets whose refresh failed are evicted from the cache, so that requests fall back to lazy fetches
+// and the authorizer availability policy. Except for the ServeStale policy which relies on the cached stages.
+type Refresher struct {
+	auth      *DefaultAuthorizer
+	secretIDs []string
+	cfg       *RefresherConfig
+}
+
+func NewRefresher(auth *DefaultAuthorizer, secretIDs []string, opts ...func(*RefresherConfig)) *Refresher {
+	cfg := &RefresherConfig{
+		RefreshInterval: 20 * time.Minute,
+		Jitter:          2 * time.Minute,
+		RetryInterval:   10 * time.Second,
+		PollInterval:    time.Minute,
+	}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		opt(cfg)
+	}
+
+	return &Refresher{auth: auth, secretIDs: secretIDs, cfg: cfg}
+}
+
+// Prefetch fetches the stages of all the secrets. All the secrets are fetched
+// even if some of them fail, the first failure is returned.
+func (r *Refresher) Prefetch(ctx context.Context) error {
+	var first error
+	for _, secretID := range r.secretIDs {
+		if err := r.auth.Refresh(ctx, secretID); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Run refreshes the secrets in background until the context is done.
+// The optional onError function is called on each failed refresh or rotation check.
+func (r *Refresher) Run(ctx context.Context, onError func(secretID string, err error)) {
+	for _, secretID := range r.secretIDs {
+		go r.run(ctx, secretID, onError)
+	}
+}
+
+func (r *Refresher) run(ctx context.Context, secretID string, onError func(secretID string, err error)) {
+	report := func(err error) {
+		if onError != nil {
+			onError(secretID, err)
+		}
+	}
+
+	refresh := func() time.Duration {
+		if err := r.auth.Refresh(ctx, secretID); err != nil {
+			report(err)
+			if r.auth.cfg.Availability != ServeStale {
+				cur := r.auth.janitor.getStage(secretID, VersionCurrent)
+				if !cur.fetchedAt.IsZero() && time.Since(cur.fetchedAt) >= r.cfg.RefreshInterval {
+					r.auth.janitor.evict(secretID)
+				}
+			}
+			return r.cfg.RetryInterval
+		}
+		return r.next()
+	}
+
+	timer := time.NewTimer(r.next())
+	defer timer.Stop()
+
+	var poll <-chan time.Time
+	if _, ok := r.auth.source.(SecretDescriber); ok && r.cfg.PollInterval > 0 {
+		ticker := time.NewTicker(r.cfg.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			timer.Reset(refresh())
+		case <-poll:
+			rotated, err := r.auth.rotated(ctx, secretID)
+			if err != nil {
+				report(err)
+				continue
+			}
+			if rotated {
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(refresh())
+			}
+		}
+	}
+}
+
+// next returns the delay before the next refresh, brought forward by a random jitter.
+func (r *Refresher) next() time.Duration {
+	d := r.cfg.RefreshInterval
+	if r.cfg.Jitter > 0 {
+		d -= time.Duration(rand.Int63n(int64(r.cfg.Jitter)))
+	}
+	if d <= 0 {
+		d = r.cfg.RetryInterval
+	}
+	return d
+}
@@ -0,0 +1,145 @@
+package secretsmanager
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+func TestRefresher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+
+	var mu sync.Mutex
+	values := map[string]string{
+		VersionCurrent:  "current_value",
+		VersionPrevious: "previous_value",
+	}
+	versions := map[string]string{
+		VersionCurrent:  "v2",
+		VersionPrevious: "v1",
+	}
+	var failure error
+
+	cli := &MockClient{
+		GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if failure != nil {
+				return nil, failure
+			}
+			stage := aws.ToString(gsvi.VersionStage)
+			if _, ok := values[stage]; !ok {
+				return nil, ErrSecretNotFound
+			}
+			return &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String(values[stage]),
+				VersionId:    aws.String(versions[stage]),
+				CreatedDate:  aws.Time(time.Now().Add(-time.Hour)),
+			}, nil
+		},
+		DescribeSecretFunc: func(ctx context.Context, dsi *secretsmanager.DescribeSecretInput, f ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			stages := map[string][]string{}
+			for stage, id := range versions {
+				stages[id] = append(stages[id], stage)
+			}
+			return &secretsmanager.DescribeSecretOutput{VersionIdsToStages: stages}, nil
+		},
+	}
+
+	auth := NewAuthorizer(cli, NewJanitor(time.Hour))
+
+	r := NewRefresher(auth, []string{secret}, func(rc *RefresherConfig) {
+		rc.RefreshInterval = time.Hour
+		rc.PollInterval = 10 * time.Millisecond
+		rc.RetryInterval = 10 * time.Millisecond
+	})
+
+	t.Run("with prefetch", func(t *testing.T) {
+		if err := r.Prefetch(ctx); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+
+		d, err := auth.Decide(ctx, secret, values[VersionCurrent])
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if !d.Cached || d.RemoteCalls != 0 {
+			t.Fatalf("expect decision be cached, got %+v", d)
+		}
+	})
+
+	spyErrs := int32(0)
+	r.Run(ctx, func(secretID string, err error) {
+		atomic.AddInt32(&spyErrs, 1)
+	})
+
+	t.Run("with rotation detected", func(t *testing.T) {
+		mu.Lock()
+		values[VersionPrevious], versions[VersionPrevious] = values[VersionCurrent], versions[VersionCurrent]
+		values[VersionCurrent], versions[VersionCurrent] = "new_current_value", "v3"
+		mu.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+
+		d, err := auth.Decide(ctx, secret, "new_current_value")
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if !d.Cached || d.RemoteCalls != 0 {
+			t.Fatalf("expect decision be cached, got %+v", d)
+		}
+		if want, got := int32(0), atomic.LoadInt32(&spyErrs); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with expired secret evicted", func(t *testing.T) {
+		auth := NewAuthorizer(cli, NewJanitor(time.Hour))
+
+		r := NewRefresher(auth, []string{secret}, func(rc *RefresherConfig) {
+			rc.RefreshInterval = 50 * time.Millisecond
+			rc.Jitter = 0
+			rc.RetryInterval = 10 * time.Millisecond
+			rc.PollInterval = 0
+		})
+		if err := r.Prefetch(ctx); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+
+		mu.Lock()
+		failure = errors.New("infra error")
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			failure = nil
+			mu.Unlock()
+		}()
+
+		spyErrs := int32(0)
+		r.Run(ctx, func(secretID string, err error) {
+			atomic.AddInt32(&spyErrs, 1)
+		})
+
+		time.Sleep(100 * time.Millisecond)
+
+		if atomic.LoadInt32(&spyErrs) == 0 {
+			t.Fatal("expect refresh failures be reported")
+		}
+		if cur := auth.janitor.getStage(secret, VersionCurrent); !cur.IsZero() {
+			t.Fatalf("expect secret be evicted, got %v", cur)
+		}
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
@@ -15,13 +17,44 @@ const (
 	StepSet    = "setSecret"
 	StepTest   = "testSecret"
 	StepFinish = "finishSecret"
+
+	// StepRollback isn't a secretsmanager rotation step, it's invoked explicitly to cancel a rotation.
+	StepRollback = "rollbackSecret"
 )
 
 var (
 	ErrRotationInvalidStep = errors.New("invalid rotation step")
 	ErrRotationDisabled    = errors.New("rotation disabled")
+
+	ErrTokenMismatch  = errors.New("rotation token mismatch")
+	ErrPendingMissing = errors.New("pending secret version missing")
+	ErrCurrentMissing = errors.New("current secret version missing")
+	ErrRotationDone   = errors.New("rotation already done")
 )
 
+// stepError is a rotation step failure. It matches its kind (i.e. ErrPendingMissing) using errors.Is,
+// and unwraps to its cause.
+type stepError struct {
+	kind error
+	step string
+	err  error
+}
+
+func (e *stepError) Error() string {
+	if e.kind == nil {
+		return fmt.Sprintf("%s: %v", e.step, e.err)
+	}
+	return fmt.Sprintf("%s: %v: %v", e.step, e.kind, e.err)
+}
+
+func (e *stepError) Unwrap() error {
+	return e.err
+}
+
+func (e *stepError) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
+
 // Rotator interface presents a service that is able to:
 //   - Create new version of a secretsmanager secret;
 //   - Update downstream services/resources to use the new version;
@@ -32,6 +65,8 @@ type Rotator interface {
 	Set(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current, pending string) error) error
 	Test(ctx context.Context, secretARN, token string, fn func(ctx context.Context, pending string) error) error
 	Finish(ctx context.Context, secretARN, token string) error
+	RecordDeployment(ctx context.Context, secretARN, token string, at time.Time) error
+	Rollback(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current string) error) error
 }
 
 const (
@@ -40,17 +75,89 @@ const (
 	VersionPending  = "AWSPENDING"
 )
 
+// Secret tags used to record the deployment of a secret version to the downstream services.
+// The authorizer relies on them to compute the period during which the previous version remains valid.
+const (
+	TagDeployedVersion = "secure-lambda-url:deployed-version"
+	TagDeployedAt      = "secure-lambda-url:deployed-at"
+)
+
+// TagChangedVersion is the secret tag holding the version (token) whose value was pushed downstream.
+// A rollback restores the downstream services only if they were changed by the rolled back version.
+const TagChangedVersion = "secure-lambda-url:changed-version"
+
+type RotatorConfig struct {
+	// SecretField is the field rotated inside JSON secret values, either a member name or a JSON pointer
+	// (see SecretField). The rest of the document is preserved. If empty, the whole secret value is rotated.
+	SecretField string
+
+	// KeyringKey is the name of the key rotated inside a keyring secret (see Keyring).
+	// The other keys are preserved. If empty, the whole secret value is rotated.
+	KeyringKey string
+
+	// KeyFormat generates self-validating keys (see FormatKey) embedding the hint of the new secret version.
+	// The generated values must be alphanumeric.
+	KeyFormat bool
+
+	// Generator generates the new secret values. It's overridden by the TagGenerator secret tag.
+	// Default to SecretsManagerGenerator, which excludes punctuation if KeyFormat is set.
+	Generator Generator
+
+	// HeaderSafe rejects the generated values which aren't legal CloudFront custom header values
+	// (see ValidateHeaderValue).
+	HeaderSafe bool
+}
+
 // DefaultRotator implements Rotator
 type DefaultRotator struct {
 	client ClientAPI
+	cfg    *RotatorConfig
 }
 
 var _ Rotator = &DefaultRotator{}
 
-func NewDefaultRotator(cli ClientAPI) *DefaultRotator {
+func NewDefaultRotator(cli ClientAPI, opts ...func(*RotatorConfig)) *DefaultRotator {
+	cfg := &RotatorConfig{}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		opt(cfg)
+	}
+	if cfg.Generator == nil {
+		cfg.Generator = NewSecretsManagerGenerator(cli, func(g *SecretsManagerGenerator) {
+			// formatted keys are alphanumeric
+			g.ExcludePunctuation = cfg.KeyFormat
+		})
+	}
+
 	return &DefaultRotator{
 		client: cli,
+		cfg:    cfg,
+	}
+}
+
+// keyValue returns the rotated value held by the given secret value,
+// that is the secret field and then the keyring key if they are configured.
+func (r *DefaultRotator) keyValue(value string) (string, error) {
+	if r.cfg.SecretField != "" {
+		var err error
+		if value, err = SecretField(value, r.cfg.SecretField); err != nil {
+			return "", err
+		}
+	}
+	if r.cfg.KeyringKey == "" {
+		return value, nil
 	}
+	k, err := ParseKeyring(value)
+	if err != nil {
+		return "", err
+	}
+	key, ok := k.Keys[r.cfg.KeyringKey]
+	if !ok {
+		return "", fmt.Errorf("%w: key %s not found", ErrInvalidKeyring, r.cfg.KeyringKey)
+	}
+	return key.Value, nil
 }
 
 func (r *DefaultRotator) RotationEnabled(ctx context.Context, secretARN string) error {
@@ -67,145 +174,353 @@ func (r *DefaultRotator) RotationEnabled(ctx context.Context, secretARN string)
 }
 
 // Create implements Rotator.
+//
+// It puts a new value labeled AWSPENDING as the token version. It's a no-op if the token version
+// already has a value, or is already the current version.
 func (r *DefaultRotator) Create(ctx context.Context, secretARN string, token string) error {
+	out, done, err := r.checkToken(ctx, StepCreate, secretARN, token)
+	if err != nil || done {
+		return err
+	}
+
 	// Make sure secret already has a value
-	_, err := r.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(secretARN),
-		VersionStage: aws.String(VersionCurrent),
-	})
+	current, err := r.getSecretValue(ctx, StepCreate, secretARN, VersionCurrent, "")
 	if err != nil {
 		return err
 	}
 
-	// Check if secret has an ongoing rotation then abort the process
-	_, err = r.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(secretARN),
-		VersionStage: aws.String(VersionPending),
-		VersionId:    aws.String(token),
-	})
-	if err == nil {
+	// The token version already has a value, the step is retried
+	if _, err = r.getSecretValue(ctx, StepCreate, secretARN, VersionPending, token); err == nil {
 		return nil
-	}
-
-	// 'ResourceNotFoundException' indicates that no ongoing rotation is occurring.
-	// If so, generates and sets a new secret value.
-	var te *types.ResourceNotFoundException
-	if !errors.As(err, &te) {
+	} else if !errors.Is(err, ErrPendingMissing) {
 		return err
 	}
 
-	password, err := r.client.GetRandomPassword(ctx, &secretsmanager.GetRandomPasswordInput{
-		ExcludePunctuation:      aws.Bool(false),
-		IncludeSpace:            aws.Bool(false),
-		PasswordLength:          aws.Int64(64),
-		RequireEachIncludedType: aws.Bool(true),
-	})
+	gen, err := r.generator(out)
 	if err != nil {
-		return err
+		return &stepError{step: StepCreate, err: err}
 	}
-	_, err = r.client.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
-		SecretId:      aws.String(secretARN),
-		VersionStages: []string{VersionPending},
-		SecretString:  password.RandomPassword,
-	})
+	value, err := r.newValue(ctx, gen, aws.ToString(current.SecretString), token)
 	if err != nil {
-		return err
+		return &stepError{step: StepCreate, err: err}
+	}
+
+	// the client request token makes the token the ID of the new version, and the call idempotent
+	if _, err = r.client.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:           aws.String(secretARN),
+		ClientRequestToken: aws.String(token),
+		VersionStages:      []string{VersionPending},
+		SecretString:       aws.String(value),
+	}); err != nil {
+		return &stepError{step: StepCreate, err: err}
 	}
 
 	return nil
 }
 
-// Finish implements Rotator.
-func (r *DefaultRotator) Finish(ctx context.Context, secretARN string, token string) error {
-	// Get secret associated versions
-	out, err := r.client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+// newValue generates the new secret value of the given token version, based on the current secret value.
+func (r *DefaultRotator) newValue(ctx context.Context, gen Generator, document, token string) (string, error) {
+	value, err := gen.Generate(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if r.cfg.KeyFormat {
+		if strings.Trim(value, AlphabetAlphanumeric) != "" {
+			return "", fmt.Errorf("%w: formatted keys require alphanumeric values", ErrInvalidGenerator)
+		}
+		value = FormatKey(token, value)
+	}
+	if r.cfg.HeaderSafe {
+		if err := ValidateHeaderValue(value); err != nil {
+			return "", err
+		}
+	}
+
+	field := document
+	if r.cfg.SecretField != "" {
+		if field, err = SecretField(document, r.cfg.SecretField); err != nil {
+			return "", err
+		}
+	}
+	if r.cfg.KeyringKey != "" {
+		// only the rotated key changes, its restrictions and the other keys are preserved
+		k, err := ParseKeyring(field)
+		if err != nil {
+			return "", err
+		}
+		if k.Keys == nil {
+			k.Keys = make(map[string]KeyringKey)
+		}
+		key := k.Keys[r.cfg.KeyringKey]
+		key.Value = value
+		k.Keys[r.cfg.KeyringKey] = key
+		value = k.String()
+	}
+	if r.cfg.SecretField != "" {
+		// only the rotated field changes, the rest of the document is preserved
+		if value, err = SetSecretField(document, r.cfg.SecretField, value); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+// generator returns the generator of the described secret: the one held by the TagGenerator secret tag if any,
+// otherwise the configured one.
+func (r *DefaultRotator) generator(out *secretsmanager.DescribeSecretOutput) (Generator, error) {
+	for _, tag := range out.Tags {
+		if aws.ToString(tag.Key) == TagGenerator {
+			return ParseGenerator(r.client, aws.ToString(tag.Value))
+		}
+	}
+	return r.cfg.Generator, nil
+}
+
+// checkToken describes the given secret, and checks the token is a version labeled AWSPENDING.
+// It reports whether the rotation is already done, that is if the token version is labeled AWSCURRENT.
+func (r *DefaultRotator) checkToken(ctx context.Context, step, secretARN, token string) (out *secretsmanager.DescribeSecretOutput, done bool, err error) {
+	out, err = r.client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
 		SecretId: aws.String(secretARN),
 	})
 	if err != nil {
+		return nil, false, &stepError{step: step, err: err}
+	}
+	if out == nil {
+		out = &secretsmanager.DescribeSecretOutput{}
+	}
+
+	stages, ok := out.VersionIdsToStages[token]
+	switch {
+	case !ok:
+		return nil, false, &stepError{kind: ErrTokenMismatch, step: step, err: fmt.Errorf("version %s not found", token)}
+	case hasStage(stages, VersionCurrent):
+		return out, true, nil
+	case !hasStage(stages, VersionPending):
+		return nil, false, &stepError{kind: ErrTokenMismatch, step: step, err: fmt.Errorf("version %s not labeled %s", token, VersionPending)}
+	}
+	return out, false, nil
+}
+
+// getSecretValue gets the secret value of the given stage, and version if not empty.
+// A missing AWSCURRENT or AWSPENDING value fails with ErrCurrentMissing or ErrPendingMissing.
+func (r *DefaultRotator) getSecretValue(ctx context.Context, step, secretARN, stage, versionID string) (*secretsmanager.GetSecretValueOutput, error) {
+	in := &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(secretARN),
+		VersionStage: aws.String(stage),
+	}
+	if versionID != "" {
+		in.VersionId = aws.String(versionID)
+	}
+	out, err := r.client.GetSecretValue(ctx, in)
+	if err != nil {
+		var te *types.ResourceNotFoundException
+		if !errors.As(err, &te) {
+			return nil, &stepError{step: step, err: err}
+		}
+		kind := ErrPendingMissing
+		if stage == VersionCurrent {
+			kind = ErrCurrentMissing
+		}
+		return nil, &stepError{kind: kind, step: step, err: err}
+	}
+	if out == nil {
+		out = &secretsmanager.GetSecretValueOutput{}
+	}
+	return out, nil
+}
+
+// Finish implements Rotator.
+//
+// It moves the AWSCURRENT label to the token version. It's a no-op if the token version is already the current one.
+func (r *DefaultRotator) Finish(ctx context.Context, secretARN string, token string) error {
+	out, done, err := r.checkToken(ctx, StepFinish, secretARN, token)
+	if err != nil || done {
 		return err
 	}
 
 	current := ""
-	if versions := out.VersionIdsToStages; versions != nil {
-	LOOP:
-		for ver, stages := range versions {
-			for _, stage := range stages {
-				if stage == VersionCurrent {
-					// The correct version is already marked as current, return
-					if token == ver {
-						return nil
-					}
-					current = ver
-					break LOOP
-				}
-			}
-		}
-	}
-
-	if _, err = r.client.UpdateSecretVersionStage(
-		ctx, &secretsmanager.UpdateSecretVersionStageInput{
-			SecretId:            aws.String(secretARN),
-			VersionStage:        aws.String(VersionCurrent),
-			MoveToVersionId:     aws.String(token),
-			RemoveFromVersionId: aws.String(current),
-		},
-	); err != nil {
-		return err
+	for ver, stages := range out.VersionIdsToStages {
+		if hasStage(stages, VersionCurrent) {
+			current = ver
+			break
+		}
+	}
+
+	in := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:        aws.String(secretARN),
+		VersionStage:    aws.String(VersionCurrent),
+		MoveToVersionId: aws.String(token),
+	}
+	if current != "" {
+		in.RemoveFromVersionId = aws.String(current)
+	}
+	if _, err = r.client.UpdateSecretVersionStage(ctx, in); err != nil {
+		return &stepError{step: StepFinish, err: err}
 	}
 
 	return nil
 }
 
 // Set implements Rotator.
+//
+// It passes the AWSCURRENT and the token AWSPENDING values to the given function, which must be idempotent
+// as the step may be retried. It's a no-op if the token version is already the current one.
+// The token version is recorded as changed (see RecordChange) before the function is called,
+// so that a partial update is rolled back too.
 func (r *DefaultRotator) Set(ctx context.Context, secretARN string, token string, fn func(ctx context.Context, current, pending string) error) error {
-	if fn == nil {
-		return nil
+	_, done, err := r.checkToken(ctx, StepSet, secretARN, token)
+	if err != nil || done {
+		return err
 	}
 
-	// Make sure an ongoing rotation exists, and it's the current one (based on token)
-	pending, err := r.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(secretARN),
-		VersionStage: aws.String(VersionPending),
-		VersionId:    aws.String(token),
-	})
+	pending, err := r.getSecretValue(ctx, StepSet, secretARN, VersionPending, token)
+	if err != nil {
+		return err
+	}
+	current, err := r.getSecretValue(ctx, StepSet, secretARN, VersionCurrent, "")
 	if err != nil {
+		return err
+	}
+	if fn == nil {
 		return nil
 	}
 
-	// Get the CURRENT value and pass both PENDING and CURRENT to the updater function
-	current, err := r.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(secretARN),
-		VersionStage: aws.String(VersionCurrent),
-	})
+	currentValue, err := r.keyValue(aws.ToString(current.SecretString))
 	if err != nil {
-		return nil
+		return &stepError{step: StepSet, err: err}
+	}
+	pendingValue, err := r.keyValue(aws.ToString(pending.SecretString))
+	if err != nil {
+		return &stepError{step: StepSet, err: err}
 	}
 
-	if err := fn(ctx, aws.ToString(current.SecretString), aws.ToString(pending.SecretString)); err != nil {
-		return err
+	if err := r.RecordChange(ctx, secretARN, token); err != nil {
+		return &stepError{step: StepSet, err: err}
+	}
+	if err := fn(ctx, currentValue, pendingValue); err != nil {
+		return &stepError{step: StepSet, err: err}
 	}
 
 	return nil
 }
 
 // Test implements Rotator.
+//
+// It passes the token AWSPENDING value to the given function, which must be idempotent
+// as the step may be retried. It's a no-op if the token version is already the current one.
 func (r *DefaultRotator) Test(ctx context.Context, secretARN, token string, fn func(ctx context.Context, pending string) error) error {
+	_, done, err := r.checkToken(ctx, StepTest, secretARN, token)
+	if err != nil || done {
+		return err
+	}
+
+	pending, err := r.getSecretValue(ctx, StepTest, secretARN, VersionPending, token)
+	if err != nil {
+		return err
+	}
 	if fn == nil {
 		return nil
 	}
 
-	pending, err := r.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(secretARN),
-		VersionStage: aws.String(VersionPending),
-		VersionId:    aws.String(token),
-	})
+	pendingValue, err := r.keyValue(aws.ToString(pending.SecretString))
 	if err != nil {
-		return nil
+		return &stepError{step: StepTest, err: err}
+	}
+
+	if err := fn(ctx, pendingValue); err != nil {
+		return &stepError{step: StepTest, err: err}
+	}
+
+	return nil
+}
+
+// RecordDeployment implements Rotator.
+// It tags the secret with the given version (token) and the time it was deployed downstream.
+func (r *DefaultRotator) RecordDeployment(ctx context.Context, secretARN, token string, at time.Time) error {
+	if _, err := r.client.TagResource(ctx, &secretsmanager.TagResourceInput{
+		SecretId: aws.String(secretARN),
+		Tags: []types.Tag{
+			{Key: aws.String(TagDeployedVersion), Value: aws.String(token)},
+			{Key: aws.String(TagDeployedAt), Value: aws.String(at.UTC().Format(time.RFC3339))},
+		},
+	}); err != nil {
+		return err
 	}
 
-	if err := fn(ctx, aws.ToString(pending.SecretString)); err != nil {
+	return nil
+}
+
+// RecordChange tags the secret with the given version (token), before its value is pushed downstream.
+func (r *DefaultRotator) RecordChange(ctx context.Context, secretARN, token string) error {
+	if _, err := r.client.TagResource(ctx, &secretsmanager.TagResourceInput{
+		SecretId: aws.String(secretARN),
+		Tags: []types.Tag{
+			{Key: aws.String(TagChangedVersion), Value: aws.String(token)},
+		},
+	}); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// Rollback implements Rotator.
+//
+// It cancels the rotation of the token version: it passes the AWSCURRENT value to the given function
+// if the token value was pushed downstream (see RecordChange), then removes the AWSPENDING label from the token version.
+// The label is removed even if the function fails, so that the secret isn't left with a stale pending version;
+// the function error is returned then. It's a no-op if the label is already removed, and fails with ErrRotationDone
+// if the token version is the current one.
+func (r *DefaultRotator) Rollback(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current string) error) error {
+	out, err := r.client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(secretARN),
+	})
+	if err != nil {
+		return &stepError{step: StepRollback, err: err}
+	}
+	if out == nil {
+		out = &secretsmanager.DescribeSecretOutput{}
+	}
+
+	stages, ok := out.VersionIdsToStages[token]
+	switch {
+	case !ok:
+		return &stepError{kind: ErrTokenMismatch, step: StepRollback, err: fmt.Errorf("version %s not found", token)}
+	case hasStage(stages, VersionCurrent):
+		return &stepError{kind: ErrRotationDone, step: StepRollback, err: fmt.Errorf("version %s labeled %s", token, VersionCurrent)}
+	case !hasStage(stages, VersionPending):
+		return nil
+	}
+
+	changed := false
+	for _, tag := range out.Tags {
+		if aws.ToString(tag.Key) == TagChangedVersion {
+			changed = aws.ToString(tag.Value) == token
+		}
+	}
+	var restoreErr error
+	if changed && fn != nil {
+		current, err := r.getSecretValue(ctx, StepRollback, secretARN, VersionCurrent, "")
+		if err != nil {
+			return err
+		}
+		currentValue, err := r.keyValue(aws.ToString(current.SecretString))
+		if err != nil {
+			return &stepError{step: StepRollback, err: err}
+		}
+		if err := fn(ctx, currentValue); err != nil {
+			restoreErr = &stepError{step: StepRollback, err: err}
+		}
+	}
+
+	if _, err = r.client.UpdateSecretVersionStage(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            aws.String(secretARN),
+		VersionStage:        aws.String(VersionPending),
+		RemoveFromVersionId: aws.String(token),
+	}); err != nil {
+		return &stepError{step: StepRollback, err: err}
+	}
+
+	return restoreErr
+}
@@ -2,15 +2,18 @@ package secretsmanager
 
 import (
 	"context"
+	"time"
 )
 
 // MockRotator is a mock implementation of the Rotator interface.
 type MockRotator struct {
-	RotationEnabledFn func(ctx context.Context, secretARN string) error
-	CreateFn          func(ctx context.Context, secretARN, token string) error
-	SetFn             func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current, pending string) error) error
-	TestFn            func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, pending string) error) error
-	FinishFn          func(ctx context.Context, secretARN, token string) error
+	RotationEnabledFn  func(ctx context.Context, secretARN string) error
+	CreateFn           func(ctx context.Context, secretARN, token string) error
+	SetFn              func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current, pending string) error) error
+	TestFn             func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, pending string) error) error
+	FinishFn           func(ctx context.Context, secretARN, token string) error
+	RecordDeploymentFn func(ctx context.Context, secretARN, token string, at time.Time) error
+	RollbackFn         func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current string) error) error
 }
 
 // RotationEnabled mocks the RotationEnabled method.
@@ -52,3 +55,19 @@ func (m *MockRotator) Finish(ctx context.Context, secretARN, token string) error
 	}
 	return nil
 }
+
+// RecordDeployment mocks the RecordDeployment method.
+func (m *MockRotator) RecordDeployment(ctx context.Context, secretARN, token string, at time.Time) error {
+	if m.RecordDeploymentFn != nil {
+		return m.RecordDeploymentFn(ctx, secretARN, token, at)
+	}
+	return nil
+}
+
+// Rollback mocks the Rollback method.
+func (m *MockRotator) Rollback(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current string) error) error {
+	if m.RollbackFn != nil {
+		return m.RollbackFn(ctx, secretARN, token, fn)
+	}
+	return nil
+}
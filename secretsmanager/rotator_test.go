@@ -4,110 +4,256 @@ import (
 	"context"
 	"errors"
 	"strconv"
-	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 )
 
+// rotationVersion is a secret version of the fake rotation client, its value is nil until it's put.
+type rotationVersion struct {
+	value  *string
+	stages []string
+}
+
+// rotationState is the state of a secret behind the fake rotation client.
+type rotationState struct {
+	versions map[string]*rotationVersion
+	tags     []types.Tag
+	err      error
+	puts     int
+	updates  int
+}
+
+// newRotationState returns a secret having a current version, and the given token version labeled AWSPENDING.
+// The token version has a value if pending isn't empty.
+func newRotationState(current, token, pending string) *rotationState {
+	st := &rotationState{versions: map[string]*rotationVersion{
+		"current_id": {value: aws.String(current), stages: []string{VersionCurrent}},
+	}}
+	if token != "" {
+		st.versions[token] = &rotationVersion{stages: []string{VersionPending}}
+		if pending != "" {
+			st.versions[token].value = aws.String(pending)
+		}
+	}
+	return st
+}
+
+// client returns a mock client which behaves like secretsmanager against the state.
+// The state error, if any, is returned by the calls which change the secret.
+func (st *rotationState) client(password string) *MockClient {
+	notFound := func() error { return &types.ResourceNotFoundException{Message: aws.String("version not found")} }
+	return &MockClient{
+		DescribeSecretFunc: func(ctx context.Context, dsi *secretsmanager.DescribeSecretInput, f ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+			out := &secretsmanager.DescribeSecretOutput{VersionIdsToStages: map[string][]string{}, Tags: st.tags}
+			for id, v := range st.versions {
+				out.VersionIdsToStages[id] = v.stages
+			}
+			return out, nil
+		},
+		GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+			stage := aws.ToString(gsvi.VersionStage)
+			for id, v := range st.versions {
+				if gsvi.VersionId != nil && aws.ToString(gsvi.VersionId) != id {
+					continue
+				}
+				if (stage == "" || hasStage(v.stages, stage)) && v.value != nil {
+					return &secretsmanager.GetSecretValueOutput{VersionId: aws.String(id), SecretString: v.value}, nil
+				}
+			}
+			return nil, notFound()
+		},
+		GetRandomPasswordFunc: func(ctx context.Context, grpi *secretsmanager.GetRandomPasswordInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetRandomPasswordOutput, error) {
+			return &secretsmanager.GetRandomPasswordOutput{RandomPassword: aws.String(password)}, nil
+		},
+		PutSecretValueFunc: func(ctx context.Context, psvi *secretsmanager.PutSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error) {
+			if st.err != nil {
+				return nil, st.err
+			}
+			st.puts++
+			v, ok := st.versions[aws.ToString(psvi.ClientRequestToken)]
+			if !ok {
+				v = &rotationVersion{}
+				st.versions[aws.ToString(psvi.ClientRequestToken)] = v
+			}
+			v.value, v.stages = psvi.SecretString, psvi.VersionStages
+			return &secretsmanager.PutSecretValueOutput{}, nil
+		},
+		UpdateSecretVersionStageFunc: func(ctx context.Context, usvsi *secretsmanager.UpdateSecretVersionStageInput, f ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+			if st.err != nil {
+				return nil, st.err
+			}
+			st.updates++
+			stage := aws.ToString(usvsi.VersionStage)
+			if from, ok := st.versions[aws.ToString(usvsi.RemoveFromVersionId)]; ok {
+				stages := []string{}
+				for _, s := range from.stages {
+					if s != stage {
+						stages = append(stages, s)
+					}
+				}
+				if stage == VersionCurrent {
+					stages = append(stages, VersionPrevious)
+				}
+				from.stages = stages
+			}
+			if to, ok := st.versions[aws.ToString(usvsi.MoveToVersionId)]; ok {
+				to.stages = []string{stage}
+			}
+			return &secretsmanager.UpdateSecretVersionStageOutput{}, nil
+		},
+		TagResourceFunc: func(ctx context.Context, tri *secretsmanager.TagResourceInput, f ...func(*secretsmanager.Options)) (*secretsmanager.TagResourceOutput, error) {
+			st.tags = append(st.tags, tri.Tags...)
+			return &secretsmanager.TagResourceOutput{}, nil
+		},
+	}
+}
+
 func TestRotator_Create(t *testing.T) {
 	ctx := context.Background()
 	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
 	token := "arn:aws:secretsmanager:eu-west-1:19cx3122:token/fake"
 
-	t.Run("secret must already have a current value to rotate", func(t *testing.T) {
-		mockErr := &types.ResourceNotFoundException{}
-		cli := &MockClient{
-			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
-				return nil, mockErr
-			},
-		}
+	type tc struct {
+		name    string
+		state   *rotationState
+		wantErr error
+		puts    int
+		pending string
+	}
 
-		err := NewDefaultRotator(cli).Create(ctx, secret, token)
-		if got, want := err, mockErr; !errors.Is(got, want) {
-			t.Fatalf("expect %v is %v", got, want)
-		}
-	})
+	noCurrent := newRotationState("", token, "")
+	delete(noCurrent.versions, "current_id")
+
+	infraErr := errors.New("infra error")
+	failing := newRotationState("current_value", token, "")
+	failing.err = infraErr
+
+	completed := newRotationState("old_value", "", "")
+	completed.versions["current_id"].stages = []string{VersionPrevious}
+	completed.versions[token] = &rotationVersion{value: aws.String("new_value"), stages: []string{VersionCurrent}}
+
+	tcs := []tc{
+		{
+			name:    "create pending value",
+			state:   newRotationState("current_value", token, ""),
+			puts:    1,
+			pending: "new_value",
+		},
+		{
+			name:    "pending value already created",
+			state:   newRotationState("current_value", token, "pending_value"),
+			pending: "pending_value",
+		},
+		{
+			name:    "rotation already completed",
+			state:   completed,
+			pending: "new_value",
+		},
+		{
+			name:    "unknown token",
+			state:   newRotationState("current_value", "", ""),
+			wantErr: ErrTokenMismatch,
+		},
+		{
+			name:    "secret without current value",
+			state:   noCurrent,
+			wantErr: ErrCurrentMissing,
+		},
+		{
+			name:    "infra error",
+			state:   failing,
+			wantErr: infraErr,
+		},
+	}
 
-	t.Run("do not create new secret during ongoing rotation", func(t *testing.T) {
-		spyCalls := int32(0)
-		cli := &MockClient{
-			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
-				// for clarity reasons, explicitly return a value for the 'PENDING' case
-				// even if it's the same as default
-				if aws.ToString(gsvi.VersionStage) == VersionPending {
-					return &secretsmanager.GetSecretValueOutput{}, nil
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rotator := NewDefaultRotator(tc.state.client("new_value"))
+			// the step is invoked again as if the rotation lambda was retried
+			for i := 0; i < 2; i++ {
+				err := rotator.Create(ctx, secret, token)
+				if want, got := tc.wantErr, err; !errors.Is(got, want) {
+					t.Fatalf("expect %v, %v be equals", want, got)
 				}
-				return &secretsmanager.GetSecretValueOutput{}, nil
-			},
-
-			GetRandomPasswordFunc: func(ctx context.Context, grpi *secretsmanager.GetRandomPasswordInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetRandomPasswordOutput, error) {
-				atomic.AddInt32(&spyCalls, 1)
-				return &secretsmanager.GetRandomPasswordOutput{}, nil
-			},
-			PutSecretValueFunc: func(ctx context.Context, psvi *secretsmanager.PutSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error) {
-				atomic.AddInt32(&spyCalls, 1)
-				return &secretsmanager.PutSecretValueOutput{}, nil
-			},
-		}
+			}
+			if want, got := tc.puts, tc.state.puts; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			if want, got := tc.pending, aws.ToString(tc.state.versions[token].value); want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		})
+	}
+
+	t.Run("rotate a single keyring key", func(t *testing.T) {
+		current := `{"keys":{"cloudfront":{"value":"old_value"},"partner":{"value":"partner_value","paths":["/api"]}}}`
+		st := newRotationState(current, token, "")
 
-		err := NewDefaultRotator(cli).Create(ctx, secret, token)
+		rotator := NewDefaultRotator(st.client("new_value"), func(rc *RotatorConfig) {
+			rc.KeyringKey = "cloudfront"
+		})
+		if err := rotator.Create(ctx, secret, token); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		k, err := ParseKeyring(aws.ToString(st.versions[token].value))
 		if err != nil {
 			t.Fatalf("expect err be nil, got %v", err)
 		}
-		if spyCalls != 0 {
-			t.Fatal("expect 'put secret logic' to not be reached")
+		if want, got := "new_value", k.Keys["cloudfront"].Value; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if want, got := "partner_value", k.Keys["partner"].Value; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if want, got := 1, len(k.Keys["partner"].Paths); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
 		}
 	})
 
-	t.Run("new secret should be created", func(t *testing.T) {
-		mockErr := &types.ResourceNotFoundException{}
-		spyCalls := int32(0)
-		cli := &MockClient{
-			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
-				if aws.ToString(gsvi.VersionStage) == VersionPending {
-					return nil, mockErr
-				}
-				return &secretsmanager.GetSecretValueOutput{}, nil
-			},
-			GetRandomPasswordFunc: func(ctx context.Context, grpi *secretsmanager.GetRandomPasswordInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetRandomPasswordOutput, error) {
-				atomic.AddInt32(&spyCalls, 1)
-				return &secretsmanager.GetRandomPasswordOutput{}, nil
-			},
-			PutSecretValueFunc: func(ctx context.Context, psvi *secretsmanager.PutSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error) {
-				atomic.AddInt32(&spyCalls, 1)
-				return &secretsmanager.PutSecretValueOutput{}, nil
-			},
-		}
+	t.Run("rotate a single secret field", func(t *testing.T) {
+		st := newRotationState(`{"apiKey":"old_value","password":"db_value"}`, token, "")
 
-		err := NewDefaultRotator(cli).Create(ctx, secret, token)
-		if err != nil {
+		rotator := NewDefaultRotator(st.client("new_value"), func(rc *RotatorConfig) {
+			rc.SecretField = "apiKey"
+		})
+		if err := rotator.Create(ctx, secret, token); err != nil {
 			t.Fatalf("expect err be nil, got %v", err)
 		}
-		if spyCalls != 2 {
-			t.Fatal("expect 'put secret logic' to be executed")
+		if want, got := `{"apiKey":"new_value","password":"db_value"}`, aws.ToString(st.versions[token].value); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
 		}
 	})
 
-	t.Run("secret rotation create failed due to infra error", func(t *testing.T) {
-		mockErr := errors.New("infra error")
-		cli := &MockClient{
-			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
-				if aws.ToString(gsvi.VersionStage) == VersionPending {
-					return nil, &types.ResourceNotFoundException{}
-				}
-				return &secretsmanager.GetSecretValueOutput{}, nil
-			},
-			GetRandomPasswordFunc: func(ctx context.Context, grpi *secretsmanager.GetRandomPasswordInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetRandomPasswordOutput, error) {
-				return nil, mockErr
-			},
+	t.Run("create formatted key", func(t *testing.T) {
+		st := newRotationState("current_value", token, "")
+		cli := st.client("4Hq9mZt1b0X3kR7uWc2yPn8sLd5vGe6j")
+		gen := cli.GetRandomPasswordFunc
+		cli.GetRandomPasswordFunc = func(ctx context.Context, grpi *secretsmanager.GetRandomPasswordInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetRandomPasswordOutput, error) {
+			if !aws.ToBool(grpi.ExcludePunctuation) {
+				return nil, errors.New("expect punctuation be excluded")
+			}
+			return gen(ctx, grpi, f...)
 		}
 
-		err := NewDefaultRotator(cli).Create(ctx, secret, token)
-		if got, want := err, mockErr; !errors.Is(got, want) {
-			t.Fatalf("expect %v is %v", got, want)
+		rotator := NewDefaultRotator(cli, func(rc *RotatorConfig) {
+			rc.KeyFormat = true
+		})
+		if err := rotator.Create(ctx, secret, token); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		hint, ok := ParseKey(aws.ToString(st.versions[token].value))
+		if !ok {
+			t.Fatalf("expect key %s be valid", aws.ToString(st.versions[token].value))
+		}
+		if want, got := VersionHint(token), hint; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
 		}
 	})
 }
@@ -117,49 +263,114 @@ func TestRotator_Set(t *testing.T) {
 	secret := "arn:aws:secretmanager:eu-west-1:19cx3122:secret/fake"
 	token := "arn:aws:secretmanager:eu-west-1:19cx3122:token/fake"
 
-	t.Run("ongoing rotation is required", func(t *testing.T) {
-		mockErr := &types.ResourceNotFoundException{}
-		spyCalls := int32(0)
-		cli := &MockClient{
-			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
-				if aws.ToString(gsvi.VersionStage) == VersionPending {
-					return nil, mockErr
+	type tc struct {
+		name    string
+		state   *rotationState
+		fnErr   error
+		wantErr error
+		calls   int
+		changed bool
+	}
+
+	mismatch := newRotationState("current_value", "", "")
+	mismatch.versions[token] = &rotationVersion{value: aws.String("pending_value")}
+
+	completed := newRotationState("current_value", "", "")
+	completed.versions["current_id"].stages = []string{VersionPrevious}
+	completed.versions[token] = &rotationVersion{value: aws.String("pending_value"), stages: []string{VersionCurrent}}
+
+	fnErr := errors.New("update failed")
+
+	tcs := []tc{
+		{
+			name:    "set pending value",
+			state:   newRotationState("current_value", token, "pending_value"),
+			calls:   2,
+			changed: true,
+		},
+		{
+			name:    "pending value not created",
+			state:   newRotationState("current_value", token, ""),
+			wantErr: ErrPendingMissing,
+		},
+		{
+			name:    "token version not labeled pending",
+			state:   mismatch,
+			wantErr: ErrTokenMismatch,
+		},
+		{
+			name:  "rotation already completed",
+			state: completed,
+		},
+		{
+			name:    "update failed",
+			state:   newRotationState("current_value", token, "pending_value"),
+			fnErr:   fnErr,
+			wantErr: fnErr,
+			calls:   2,
+			changed: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			fn := func(ctx context.Context, current, pending string) error {
+				calls++
+				if want, got := "current_value", current; want != got {
+					t.Fatalf("expect %v, %v be equals", want, got)
 				}
-				return &secretsmanager.GetSecretValueOutput{}, nil
-			},
-		}
-		fn := func(ctx context.Context, current, pending string) error {
-			atomic.AddInt32(&spyCalls, 1)
-			return nil
-		}
+				if want, got := "pending_value", pending; want != got {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+				return tc.fnErr
+			}
 
-		err := NewDefaultRotator(cli).Set(ctx, secret, token, fn)
-		if err != nil {
-			t.Fatalf("expect err be nil, got %v", err)
-		}
-		if spyCalls != 0 {
-			t.Fatal("expect 'fn' to not be executed")
-		}
-	})
+			rotator := NewDefaultRotator(tc.state.client(""))
+			// the step is invoked again as if the rotation lambda was retried
+			for i := 0; i < 2; i++ {
+				err := rotator.Set(ctx, secret, token, fn)
+				if want, got := tc.wantErr, err; !errors.Is(got, want) {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+			}
+			if want, got := tc.calls, calls; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			// the token version is recorded as changed only once validated
+			changed := false
+			for _, tag := range tc.state.tags {
+				changed = changed || (aws.ToString(tag.Key) == TagChangedVersion && aws.ToString(tag.Value) == token)
+			}
+			if want, got := tc.changed, changed; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		})
+	}
 
-	t.Run("set new secret", func(t *testing.T) {
-		spyCalls := int32(0)
-		cli := &MockClient{
-			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
-				return &secretsmanager.GetSecretValueOutput{}, nil
-			},
+	t.Run("set keyring key", func(t *testing.T) {
+		keyring := func(value string) string {
+			return `{"keys":{"cloudfront":{"value":"` + value + `"},"partner":{"value":"partner_value"}}}`
 		}
+		st := newRotationState(keyring("current_value"), token, keyring("pending_value"))
+
+		var gotCurrent, gotPending string
 		fn := func(ctx context.Context, current, pending string) error {
-			atomic.AddInt32(&spyCalls, 1)
+			gotCurrent, gotPending = current, pending
 			return nil
 		}
 
-		err := NewDefaultRotator(cli).Set(ctx, secret, token, fn)
-		if err != nil {
+		rotator := NewDefaultRotator(st.client(""), func(rc *RotatorConfig) {
+			rc.KeyringKey = "cloudfront"
+		})
+		if err := rotator.Set(ctx, secret, token, fn); err != nil {
 			t.Fatalf("expect err be nil, got %v", err)
 		}
-		if spyCalls != 1 {
-			t.Fatal("expect 'fn' to be executed")
+		if want, got := "current_value", gotCurrent; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if want, got := "pending_value", gotPending; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
 		}
 	})
 }
@@ -169,108 +380,264 @@ func TestRotator_Test(t *testing.T) {
 	secret := "arn:aws:secretmanager:eu-west-1:19cx3122:secret/fake"
 	token := "arn:aws:secretmanager:eu-west-1:19cx3122:token/fake"
 
-	t.Run("with ongoing rotation is required", func(t *testing.T) {
-		mockErr := &types.ResourceNotFoundException{}
-		spyCalls := int32(0)
-		cli := &MockClient{
-			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
-				if aws.ToString(gsvi.VersionStage) == VersionPending {
-					return nil, mockErr
+	type tc struct {
+		name    string
+		state   *rotationState
+		fnErr   error
+		wantErr error
+		calls   int
+	}
+
+	completed := newRotationState("current_value", "", "")
+	completed.versions["current_id"].stages = []string{VersionPrevious}
+	completed.versions[token] = &rotationVersion{value: aws.String("pending_value"), stages: []string{VersionCurrent}}
+
+	fnErr := errors.New("test failed")
+
+	tcs := []tc{
+		{
+			name:  "test pending value",
+			state: newRotationState("current_value", token, "pending_value"),
+			calls: 2,
+		},
+		{
+			name:    "pending value not created",
+			state:   newRotationState("current_value", token, ""),
+			wantErr: ErrPendingMissing,
+		},
+		{
+			name:    "unknown token",
+			state:   newRotationState("current_value", "", ""),
+			wantErr: ErrTokenMismatch,
+		},
+		{
+			name:  "rotation already completed",
+			state: completed,
+		},
+		{
+			name:    "test failed",
+			state:   newRotationState("current_value", token, "pending_value"),
+			fnErr:   fnErr,
+			wantErr: fnErr,
+			calls:   2,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			fn := func(ctx context.Context, pending string) error {
+				calls++
+				if want, got := "pending_value", pending; want != got {
+					t.Fatalf("expect %v, %v be equals", want, got)
 				}
-				return &secretsmanager.GetSecretValueOutput{}, nil
-			},
-		}
-		fn := func(ctx context.Context, pending string) error {
-			atomic.AddInt32(&spyCalls, 1)
-			return nil
-		}
+				return tc.fnErr
+			}
 
-		err := NewDefaultRotator(cli).Test(ctx, secret, token, fn)
-		if err != nil {
-			t.Fatalf("expect err be nil, got %v", err)
-		}
-		if spyCalls != 0 {
-			t.Fatal("expect 'fn' to not be executed")
-		}
-	})
+			rotator := NewDefaultRotator(tc.state.client(""))
+			// the step is invoked again as if the rotation lambda was retried
+			for i := 0; i < 2; i++ {
+				err := rotator.Test(ctx, secret, token, fn)
+				if want, got := tc.wantErr, err; !errors.Is(got, want) {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+			}
+			if want, got := tc.calls, calls; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		})
+	}
+}
 
-	t.Run("with new secret", func(t *testing.T) {
-		spyCalls := int32(0)
-		cli := &MockClient{
-			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
-				return &secretsmanager.GetSecretValueOutput{}, nil
-			},
-		}
-		fn := func(ctx context.Context, pending string) error {
-			atomic.AddInt32(&spyCalls, 1)
-			return nil
-		}
+func TestRotator_Finish(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretmanager:eu-west-1:19cx3122:secret/fake"
+	token := "arn:aws:secretmanager:eu-west-1:19cx3122:token/fake"
 
-		err := NewDefaultRotator(cli).Test(ctx, secret, token, fn)
-		if err != nil {
-			t.Fatalf("expect err be nil, got %v", err)
-		}
-		if spyCalls != 1 {
-			t.Fatal("expect 'fn' to be executed")
-		}
-	})
+	type tc struct {
+		name    string
+		state   *rotationState
+		wantErr error
+		updates int
+	}
 
+	completed := newRotationState("current_value", "", "")
+	completed.versions["current_id"].stages = []string{VersionPrevious}
+	completed.versions[token] = &rotationVersion{value: aws.String("pending_value"), stages: []string{VersionCurrent}}
+
+	infraErr := errors.New("infra error")
+	failing := newRotationState("current_value", token, "pending_value")
+	failing.err = infraErr
+
+	tcs := []tc{
+		{
+			name:    "mark pending version as current",
+			state:   newRotationState("current_value", token, "pending_value"),
+			updates: 1,
+		},
+		{
+			name:  "already marked as current",
+			state: completed,
+		},
+		{
+			name:    "unknown token",
+			state:   newRotationState("current_value", "", ""),
+			wantErr: ErrTokenMismatch,
+		},
+		{
+			name:    "infra error",
+			state:   failing,
+			wantErr: infraErr,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rotator := NewDefaultRotator(tc.state.client(""))
+			// the step is invoked again as if the rotation lambda was retried
+			for i := 0; i < 2; i++ {
+				err := rotator.Finish(ctx, secret, token)
+				if want, got := tc.wantErr, err; !errors.Is(got, want) {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+			}
+			if want, got := tc.updates, tc.state.updates; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			if want, got := []string{VersionCurrent}, tc.state.versions[token].stages; len(got) != 1 || want[0] != got[0] {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := []string{VersionPrevious}, tc.state.versions["current_id"].stages; len(got) != 1 || want[0] != got[0] {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		})
+	}
 }
 
-func TestRotator_Finish(t *testing.T) {
+func TestRotator_Rollback(t *testing.T) {
 	ctx := context.Background()
 	secret := "arn:aws:secretmanager:eu-west-1:19cx3122:secret/fake"
 	token := "arn:aws:secretmanager:eu-west-1:19cx3122:token/fake"
 
-	t.Run("with already marked as current", func(t *testing.T) {
-		spyCalls := int32(0)
-		cli := &MockClient{
-			DescribeSecretFunc: func(ctx context.Context, dsi *secretsmanager.DescribeSecretInput, f ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
-				return &secretsmanager.DescribeSecretOutput{
-					VersionIdsToStages: map[string][]string{
-						token: {VersionCurrent},
-					},
-				}, nil
-			},
-			UpdateSecretVersionStageFunc: func(ctx context.Context, usvsi *secretsmanager.UpdateSecretVersionStageInput, f ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
-				atomic.AddInt32(&spyCalls, 1)
-				return &secretsmanager.UpdateSecretVersionStageOutput{}, nil
-			},
-		}
+	type tc struct {
+		name     string
+		state    *rotationState
+		changed  bool
+		fnErr    error
+		wantErr  error
+		retryErr error
+		calls    int
+		pending  bool
+	}
 
-		err := NewDefaultRotator(cli).Finish(ctx, secret, token)
-		if err != nil {
-			t.Fatalf("expect err be nil, got %v", err)
-		}
-		if spyCalls != 0 {
-			t.Fatal("expect 'UpdateSecretVersionStageFunc' to not be executed")
-		}
-	})
+	completed := newRotationState("current_value", "", "")
+	completed.versions["current_id"].stages = []string{VersionPrevious}
+	completed.versions[token] = &rotationVersion{value: aws.String("pending_value"), stages: []string{VersionCurrent}}
+
+	fnErr := errors.New("restore failed")
+
+	tcs := []tc{
+		{
+			name:    "rollback changed version",
+			state:   newRotationState("current_value", token, "pending_value"),
+			changed: true,
+			calls:   1,
+		},
+		{
+			name:  "rollback unchanged version",
+			state: newRotationState("current_value", token, "pending_value"),
+		},
+		{
+			name:  "rollback created version",
+			state: newRotationState("current_value", token, ""),
+		},
+		{
+			// the pending label is removed anyway, the retry is a no-op
+			name:    "restore failed",
+			state:   newRotationState("current_value", token, "pending_value"),
+			changed: true,
+			fnErr:   fnErr,
+			wantErr: fnErr,
+			calls:   1,
+		},
+		{
+			name:     "rotation already completed",
+			state:    completed,
+			wantErr:  ErrRotationDone,
+			retryErr: ErrRotationDone,
+		},
+		{
+			name:     "unknown token",
+			state:    newRotationState("current_value", "", ""),
+			wantErr:  ErrTokenMismatch,
+			retryErr: ErrTokenMismatch,
+		},
+	}
 
-	t.Run("with new version marked as current", func(t *testing.T) {
-		spyCalls := int32(0)
-		cli := &MockClient{
-			DescribeSecretFunc: func(ctx context.Context, dsi *secretsmanager.DescribeSecretInput, f ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
-				return &secretsmanager.DescribeSecretOutput{
-					VersionIdsToStages: map[string][]string{
-						"new_ver": {VersionCurrent},
-					},
-				}, nil
-			},
-			UpdateSecretVersionStageFunc: func(ctx context.Context, usvsi *secretsmanager.UpdateSecretVersionStageInput, f ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
-				atomic.AddInt32(&spyCalls, 1)
-				return &secretsmanager.UpdateSecretVersionStageOutput{}, nil
-			},
-		}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			fn := func(ctx context.Context, current string) error {
+				calls++
+				if want, got := "current_value", current; want != got {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+				return tc.fnErr
+			}
 
-		err := NewDefaultRotator(cli).Finish(ctx, secret, token)
-		if err != nil {
-			t.Fatalf("expect err be nil, got %v", err)
-		}
-		if spyCalls != 1 {
-			t.Fatal("expect 'UpdateSecretVersionStageFunc' to be executed")
-		}
-	})
+			rotator := NewDefaultRotator(tc.state.client(""))
+			if tc.changed {
+				if err := rotator.RecordChange(ctx, secret, token); err != nil {
+					t.Fatalf("expect err be nil, got %v", err)
+				}
+			}
+			if want, got := tc.wantErr, rotator.Rollback(ctx, secret, token, fn); !errors.Is(got, want) {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			// the rollback is invoked again as if it was retried
+			if want, got := tc.retryErr, rotator.Rollback(ctx, secret, token, fn); !errors.Is(got, want) {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := tc.calls, calls; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if v, ok := tc.state.versions[token]; ok && tc.wantErr != ErrRotationDone {
+				if want, got := tc.pending, hasStage(v.stages, VersionPending); want != got {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRotator_RecordDeployment(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretmanager:eu-west-1:19cx3122:secret/fake"
+	token := "arn:aws:secretmanager:eu-west-1:19cx3122:token/fake"
+
+	at := time.Date(2023, 8, 1, 10, 0, 0, 0, time.UTC)
+	tags := map[string]string{}
+	cli := &MockClient{
+		TagResourceFunc: func(ctx context.Context, tri *secretsmanager.TagResourceInput, f ...func(*secretsmanager.Options)) (*secretsmanager.TagResourceOutput, error) {
+			for _, tag := range tri.Tags {
+				tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+			}
+			return &secretsmanager.TagResourceOutput{}, nil
+		},
+	}
+
+	if err := NewDefaultRotator(cli).RecordDeployment(ctx, secret, token, at); err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+	if want, got := token, tags[TagDeployedVersion]; want != got {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+	if want, got := "2023-08-01T10:00:00Z", tags[TagDeployedAt]; want != got {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
 }
 
 func TestRotator_RotationEnabled(t *testing.T) {
@@ -0,0 +1,177 @@
+package secretsmanager
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSignatureDisabled = errors.New("signature disabled")
+
+	// ErrReplayUnprotected is returned if signatures can't be protected against replays,
+	// i.e. either the clock skew or the nonce capacity isn't positive.
+	ErrReplayUnprotected = errors.New("replay protection disabled")
+)
+
+// SignatureAuthorizer presents a service that checks whether a request is signed using a secret value,
+// and describes how the decision was made.
+// The returned error is nil only if the request is authorized.
+type SignatureAuthorizer interface {
+	DecideSignature(ctx context.Context, secretID string, r SignedRequest) (Decision, error)
+}
+
+// SignedRequest holds the canonical parts of a request signed using a secret value.
+type SignedRequest struct {
+	Method string
+	Path   string
+
+	// Query is the raw query string.
+	Query string
+
+	// BodyHash is the hex encoded SHA-256 hash of the request body (see HashBody).
+	// Empty is the hash of an empty body.
+	BodyHash string
+
+	// Timestamp is the signature time in Unix seconds.
+	Timestamp string
+
+	// Nonce is a unique random value. A nonce can't be used twice during the clock skew window.
+	Nonce string
+
+	// Signature is the hex encoded HMAC-SHA256 of the string to sign, keyed by the secret value.
+	Signature string
+}
+
+// StringToSign returns the canonical string signed by the caller: the upper cased method, the path, the query,
+// the body hash, the timestamp and the nonce, joined by new lines.
+func (r SignedRequest) StringToSign() string {
+	bodyHash := r.BodyHash
+	if bodyHash == "" {
+		bodyHash = HashBody(nil)
+	}
+	return strings.Join([]string{
+		strings.ToUpper(r.Method),
+		r.Path,
+		r.Query,
+		strings.ToLower(bodyHash),
+		r.Timestamp,
+		r.Nonce,
+	}, "\n")
+}
+
+// HashBody returns the hex encoded SHA-256 hash of the given request body.
+func HashBody(body []byte) string {
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:])
+}
+
+// Sign returns the signature of the given request using the given secret value.
+func Sign(value string, r SignedRequest) string {
+	return hex.EncodeToString(hmacSHA256(value, []byte(r.StringToSign())))
+}
+
+// DecideSignature implements SignatureAuthorizer.
+//
+// The signature is checked against the same secret stages as Decide. The timestamp must be within the clock skew
+// window, and the nonce must not be seen before. Nonces are only recorded once the signature is verified, and the
+// request is denied if its nonce can't be recorded. It fails if either ClockSkew or NonceCapacity isn't positive.
+func (a *DefaultAuthorizer) DecideSignature(ctx context.Context, secretID string, r SignedRequest) (d Decision, err error) {
+	start := time.Now()
+	defer func() {
+		d.Allowed = err == nil
+		d.Latency = time.Since(start)
+	}()
+
+	if !a.cfg.SignatureEnabled {
+		d.Reason = "signature disabled"
+		return d, ErrSignatureDisabled
+	}
+	if a.cfg.ClockSkew <= 0 || a.cfg.NonceCapacity <= 0 {
+		d.Reason = "replay protection disabled"
+		return d, ErrReplayUnprotected
+	}
+	if r.Method == "" || r.Path == "" || r.Timestamp == "" || r.Nonce == "" || r.Signature == "" {
+		d.Reason = "incomplete signed request"
+		return d, ErrInvalidSecretValue
+	}
+	sig, err := hex.DecodeString(r.Signature)
+	if err != nil {
+		d.Reason = "invalid signature encoding"
+		return d, ErrInvalidSecretValue
+	}
+	ts, err := strconv.ParseInt(r.Timestamp, 10, 64)
+	if err != nil {
+		d.Reason = "invalid signature timestamp"
+		return d, ErrInvalidSecretValue
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > a.cfg.ClockSkew || skew < -a.cfg.ClockSkew {
+		d.Reason = "signature timestamp out of clock skew"
+		return d, ErrUnauthorized
+	}
+
+	nonce := a.janitor.digest(r.Nonce)
+	if a.nonceList(secretID).contains(nonce) {
+		d.Reason = "replayed nonce"
+		return d, ErrUnauthorized
+	}
+
+	// the signed parts are canonical, the signature is checked against each value of the secret
+	toSign := []byte(r.StringToSign())
+	scope := &Scope{Method: r.Method, Path: r.Path}
+	denied := ""
+	if err := a.decide(ctx, secretID, &d, true, func(s secret) bool {
+		name, reason, ok := s.matchValue(scope, func(value string) bool {
+			return hmac.Equal(hmacSHA256(value, toSign), sig)
+		})
+		if reason != "" {
+			denied = reason
+		}
+		d.KeyName = name
+		return ok
+	}); err != nil {
+		if denied != "" {
+			d.Reason = denied
+		}
+		return d, err
+	}
+
+	if err := a.recordNonce(secretID, nonce, "replayed nonce", &d); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// recordNonce records the given nonce of a verified signature, the given reason describes a replay.
+// It fails closed if the nonce can't be recorded, as it could be replayed otherwise.
+func (a *DefaultAuthorizer) recordNonce(secretID string, nonce digest, replayed string, d *Decision) error {
+	switch err := a.nonceList(secretID).insert(nonce); {
+	case errors.Is(err, errBlackListed):
+		d.Reason = replayed
+		return ErrUnauthorized
+	case err != nil:
+		d.Reason = "nonce not recorded"
+		return fmt.Errorf("%w: %v", ErrAuthorizationFailed, err)
+	}
+	return nil
+}
+
+// nonceList returns the seen nonces of the given secret.
+// Nonces are kept twice the clock skew, the timestamps validity period.
+func (a *DefaultAuthorizer) nonceList(secretID string) *blackList {
+	a.nonceMu.Lock()
+	defer a.nonceMu.Unlock()
+
+	nl, ok := a.nonces[secretID]
+	if !ok {
+		nl = newBlackList(a.cfg.NonceCapacity, 2*a.cfg.ClockSkew)
+		a.nonces[secretID] = nl
+	}
+	return nl
+}
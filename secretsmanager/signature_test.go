@@ -0,0 +1,181 @@
+package secretsmanager
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+func TestAuthorizer_DecideSignature(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+
+	values := map[string]string{
+		VersionCurrent:  "current_value",
+		VersionPrevious: "previous_value",
+	}
+	spyCalls := int32(0)
+	cli := &MockClient{
+		GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+			atomic.AddInt32(&spyCalls, 1)
+			stage := aws.ToString(gsvi.VersionStage)
+			if _, ok := values[stage]; !ok {
+				return nil, ErrSecretNotFound
+			}
+			return &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String(values[stage]),
+				VersionId:    aws.String(stage + "_id"),
+				CreatedDate:  aws.Time(time.Now().Add(-time.Hour)),
+			}, nil
+		},
+	}
+
+	nonce := int64(0)
+	newRequest := func(value string, at time.Time) SignedRequest {
+		r := SignedRequest{
+			Method:    "post",
+			Path:      "/webhook",
+			Query:     "a=1&b=2",
+			BodyHash:  HashBody([]byte(`{"id":1}`)),
+			Timestamp: strconv.FormatInt(at.Unix(), 10),
+			Nonce:     strconv.FormatInt(atomic.AddInt64(&nonce, 1), 10),
+		}
+		r.Signature = Sign(value, r)
+		return r
+	}
+
+	t.Run("with signature disabled", func(t *testing.T) {
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute))
+
+		_, err := auth.DecideSignature(ctx, secret, newRequest(values[VersionCurrent], time.Now()))
+		if want, got := ErrSignatureDisabled, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with replay protection disabled", func(t *testing.T) {
+		for _, opt := range []func(*AuthorizerConfig){
+			func(ac *AuthorizerConfig) { ac.ClockSkew = 0 },
+			func(ac *AuthorizerConfig) { ac.NonceCapacity = 0 },
+		} {
+			auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+				ac.SignatureEnabled = true
+			}, opt)
+
+			_, err := auth.DecideSignature(ctx, secret, newRequest(values[VersionCurrent], time.Now()))
+			if want, got := ErrReplayUnprotected, err; !errors.Is(got, want) {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		}
+	})
+
+	t.Run("with nonce list full", func(t *testing.T) {
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.SignatureEnabled = true
+			ac.NonceCapacity = 1
+		})
+
+		if _, err := auth.DecideSignature(ctx, secret, newRequest(values[VersionCurrent], time.Now())); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		// the first nonce isn't evicted, the request is denied instead
+		r := newRequest(values[VersionCurrent], time.Now())
+		d, err := auth.DecideSignature(ctx, secret, r)
+		if want, got := ErrAuthorizationFailed, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if want, got := "nonce not recorded", d.Reason; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+		ac.SignatureEnabled = true
+		ac.ClockSkew = time.Minute
+	})
+
+	t.Run("with valid signature", func(t *testing.T) {
+		r := newRequest(values[VersionCurrent], time.Now())
+		d, err := auth.DecideSignature(ctx, secret, r)
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := VersionCurrent, d.Stage; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+
+		// the same request is replayed
+		d, err = auth.DecideSignature(ctx, secret, r)
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if want, got := "replayed nonce", d.Reason; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with tampered request", func(t *testing.T) {
+		r := newRequest(values[VersionCurrent], time.Now())
+		r.Path = "/other"
+
+		_, err := auth.DecideSignature(ctx, secret, r)
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+
+		// invalid signatures don't call the secret source during the cool down period
+		calls := atomic.LoadInt32(&spyCalls)
+		_, err = auth.DecideSignature(ctx, secret, r)
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if want, got := calls, atomic.LoadInt32(&spyCalls); want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with expired timestamp", func(t *testing.T) {
+		d, err := auth.DecideSignature(ctx, secret, newRequest(values[VersionCurrent], time.Now().Add(-2*time.Minute)))
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if want, got := "signature timestamp out of clock skew", d.Reason; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with incomplete request", func(t *testing.T) {
+		r := newRequest(values[VersionCurrent], time.Now())
+		r.Nonce = ""
+
+		_, err := auth.DecideSignature(ctx, secret, r)
+		if want, got := ErrInvalidSecretValue, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with previous value out of grace window", func(t *testing.T) {
+		_, err := auth.DecideSignature(ctx, secret, newRequest(values[VersionPrevious], time.Now()))
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.SignatureEnabled = true
+			ac.GracePeriod = 2 * time.Hour
+		})
+		d, err := auth.DecideSignature(ctx, secret, newRequest(values[VersionPrevious], time.Now()))
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := VersionPrevious, d.Stage; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package secretsmanager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+var (
+	ErrSecretNotFound = errors.New("secret not found")
+)
+
+// SecretValue is a version of a secret fetched from a SecretSource.
+type SecretValue struct {
+	Value     string
+	VersionID string
+	CreatedAt time.Time
+}
+
+// SecretSource presents a store of secrets, whose versions are labeled by logical stages:
+// AWSCURRENT, AWSPREVIOUS and AWSPENDING.
+type SecretSource interface {
+	// Fetch returns the secret version labeled by the given stage.
+	// It returns ErrSecretNotFound if either the secret or the stage doesn't exist.
+	Fetch(ctx context.Context, secretID, stage string) (SecretValue, error)
+}
+
+// SecretDescription describes the rotation state of a secret.
+type SecretDescription struct {
+	// VersionStages maps version IDs to their stages.
+	VersionStages map[string][]string
+
+	// LastRotatedAt is the last time the secret was rotated.
+	LastRotatedAt time.Time
+
+	// DeployedVersionID is the last version recorded as deployed to the downstream services,
+	// and DeployedAt the time it was deployed.
+	DeployedVersionID string
+	DeployedAt        time.Time
+}
+
+// SecretDescriber is optionally implemented by a SecretSource able to describe the secret rotation state.
+type SecretDescriber interface {
+	Describe(ctx context.Context, secretID string) (SecretDescription, error)
+}
+
+// VersionFetcher is optionally implemented by a SecretSource able to fetch a secret version by its ID.
+type VersionFetcher interface {
+	// FetchVersion returns the given secret version.
+	// It returns ErrSecretNotFound if either the secret or the version doesn't exist.
+	FetchVersion(ctx context.Context, secretID, versionID string) (SecretValue, error)
+}
+
+// SecretsManagerSource implements SecretSource on top of the secretsmanager API.
+type SecretsManagerSource struct {
+	client ClientAPI
+}
+
+var (
+	_ SecretSource    = &SecretsManagerSource{}
+	_ SecretDescriber = &SecretsManagerSource{}
+	_ VersionFetcher  = &SecretsManagerSource{}
+)
+
+func NewSecretsManagerSource(cli ClientAPI) *SecretsManagerSource {
+	return &SecretsManagerSource{client: cli}
+}
+
+// Fetch implements SecretSource.
+func (s *SecretsManagerSource) Fetch(ctx context.Context, secretID, stage string) (SecretValue, error) {
+	return s.getSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(secretID),
+		VersionStage: aws.String(stage),
+	})
+}
+
+// FetchVersion implements VersionFetcher.
+func (s *SecretsManagerSource) FetchVersion(ctx context.Context, secretID, versionID string) (SecretValue, error) {
+	return s.getSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:  aws.String(secretID),
+		VersionId: aws.String(versionID),
+	})
+}
+
+func (s *SecretsManagerSource) getSecretValue(ctx context.Context, in *secretsmanager.GetSecretValueInput) (SecretValue, error) {
+	out, err := s.client.GetSecretValue(ctx, in)
+	if err != nil {
+		var te *types.ResourceNotFoundException
+		if errors.As(err, &te) {
+			return SecretValue{}, fmt.Errorf("%w: %v", ErrSecretNotFound, err)
+		}
+		return SecretValue{}, err
+	}
+	return SecretValue{
+		Value:     aws.ToString(out.SecretString),
+		VersionID: aws.ToString(out.VersionId),
+		CreatedAt: aws.ToTime(out.CreatedDate),
+	}, nil
+}
+
+// Describe implements SecretDescriber.
+// The deployment is read from the tags recorded by the rotation (see Rotator.RecordDeployment).
+func (s *SecretsManagerSource) Describe(ctx context.Context, secretID string) (SecretDescription, error) {
+	out, err := s.client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(secretID),
+	})
+	if err != nil {
+		var te *types.ResourceNotFoundException
+		if errors.As(err, &te) {
+			return SecretDescription{}, fmt.Errorf("%w: %v", ErrSecretNotFound, err)
+		}
+		return SecretDescription{}, err
+	}
+	if out == nil {
+		return SecretDescription{}, nil
+	}
+
+	desc := SecretDescription{
+		VersionStages: out.VersionIdsToStages,
+		LastRotatedAt: aws.ToTime(out.LastRotatedDate),
+	}
+	for _, tag := range out.Tags {
+		switch aws.ToString(tag.Key) {
+		case TagDeployedVersion:
+			desc.DeployedVersionID = aws.ToString(tag.Value)
+		case TagDeployedAt:
+			// an invalid time is ignored, the deployment is considered as not recorded
+			desc.DeployedAt, _ = time.Parse(time.RFC3339, aws.ToString(tag.Value))
+		}
+	}
+
+	return desc, nil
+}
+
+// FileSource implements SecretSource using local files.
+// The secret ID is the path of the file holding the AWSCURRENT value,
+// AWSPREVIOUS and AWSPENDING values are optionally held by '<path>.AWSPREVIOUS' and '<path>.AWSPENDING' files.
+type FileSource struct{}
+
+var _ SecretSource = FileSource{}
+
+// Fetch implements SecretSource.
+func (FileSource) Fetch(ctx context.Context, secretID, stage string) (SecretValue, error) {
+	path := secretID
+	if stage != VersionCurrent {
+		path += "." + stage
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return SecretValue{}, fmt.Errorf("%w: %s", ErrSecretNotFound, path)
+		}
+		return SecretValue{}, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return SecretValue{}, err
+	}
+	value := strings.TrimSpace(string(b))
+
+	return SecretValue{
+		Value:     value,
+		VersionID: versionOf(value),
+		CreatedAt: info.ModTime(),
+	}, nil
+}
+
+// EnvSource implements SecretSource using environment variables.
+// The secret ID is the name of the variable holding the AWSCURRENT value,
+// AWSPREVIOUS and AWSPENDING values are optionally held by '<name>_PREVIOUS' and '<name>_PENDING' variables.
+type EnvSource struct {
+	createdAt time.Time
+}
+
+var _ SecretSource = &EnvSource{}
+
+func NewEnvSource() *EnvSource {
+	// environment variables can't change during the process lifetime
+	return &EnvSource{createdAt: time.Now()}
+}
+
+// Fetch implements SecretSource.
+func (s *EnvSource) Fetch(ctx context.Context, secretID, stage string) (SecretValue, error) {
+	name := secretID
+	switch stage {
+	case VersionPrevious:
+		name += "_PREVIOUS"
+	case VersionPending:
+		name += "_PENDING"
+	}
+
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return SecretValue{}, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+
+	return SecretValue{
+		Value:     value,
+		VersionID: versionOf(value),
+		CreatedAt: s.createdAt,
+	}, nil
+}
+
+// versionOf derives a version ID from the given value, for sources that don't support versioning.
+func versionOf(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,115 @@
+package secretsmanager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+func TestSecretsManagerSource(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+
+	cli := &MockClient{
+		GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+			if aws.ToString(gsvi.VersionStage) != VersionCurrent {
+				return nil, &types.ResourceNotFoundException{}
+			}
+			return &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String("value"),
+				VersionId:    aws.String("v1"),
+			}, nil
+		},
+	}
+	src := NewSecretsManagerSource(cli)
+
+	v, err := src.Fetch(ctx, secret, VersionCurrent)
+	if err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+	if v.Value != "value" || v.VersionID != "v1" {
+		t.Fatalf("unexpected secret value %+v", v)
+	}
+
+	_, err = src.Fetch(ctx, secret, VersionPrevious)
+	if want, got := ErrSecretNotFound, err; !errors.Is(got, want) {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "secret")
+
+	if err := os.WriteFile(path, []byte("current\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+"."+VersionPrevious, []byte("previous"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	src := FileSource{}
+
+	cur, err := src.Fetch(ctx, path, VersionCurrent)
+	if err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+	if want, got := "current", cur.Value; want != got {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+	prev, err := src.Fetch(ctx, path, VersionPrevious)
+	if err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+	if want, got := "previous", prev.Value; want != got {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+	if cur.VersionID == prev.VersionID {
+		t.Fatal("expect versions be different")
+	}
+
+	_, err = src.Fetch(ctx, path, VersionPending)
+	if want, got := ErrSecretNotFound, err; !errors.Is(got, want) {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+}
+
+func TestEnvSource(t *testing.T) {
+	ctx := context.Background()
+
+	t.Setenv("SECURE_LAMBDA_URL_TEST_KEY", "current")
+	t.Setenv("SECURE_LAMBDA_URL_TEST_KEY_PENDING", "pending")
+
+	src := NewEnvSource()
+
+	for stage, want := range map[string]string{
+		VersionCurrent: "current",
+		VersionPending: "pending",
+	} {
+		v, err := src.Fetch(ctx, "SECURE_LAMBDA_URL_TEST_KEY", stage)
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if got := v.Value; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	}
+
+	_, err := src.Fetch(ctx, "SECURE_LAMBDA_URL_TEST_KEY", VersionPrevious)
+	if want, got := ErrSecretNotFound, err; !errors.Is(got, want) {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+
+	// authorize against environment variables
+	auth := NewSourceAuthorizer(src, NewJanitor(time.Minute))
+	if _, err := auth.Decide(ctx, "SECURE_LAMBDA_URL_TEST_KEY", "current"); err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package secretsmanager
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"strings"
+	"time"
+)
+
+// TokenAuthorizer presents a service that checks whether a compact JWT is signed using a secret value,
+// and describes how the decision was made.
+// The returned error is nil only if the token is authorized.
+type TokenAuthorizer interface {
+	DecideToken(ctx context.Context, secretID, token string) (Decision, error)
+}
+
+// Supported token signing algorithms.
+const (
+	TokenHS256 = "HS256"
+	TokenHS512 = "HS512"
+)
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type tokenClaims struct {
+	Iss string        `json:"iss"`
+	Aud tokenAudience `json:"aud"`
+	Exp *int64        `json:"exp"`
+	Nbf *int64        `json:"nbf"`
+}
+
+// tokenAudience is either a single string or an array of strings.
+type tokenAudience []string
+
+func (a *tokenAudience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = tokenAudience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+func (a tokenAudience) contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// SignToken returns a compact JWT of the given claims, signed using the given secret value and algorithm.
+// The key ID is the secret version ID, it's optional.
+func SignToken(value, alg, kid string, claims map[string]interface{}) (string, error) {
+	h, ok := tokenHash(alg)
+	if !ok {
+		return "", ErrInvalidSecretValue
+	}
+	hdr := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		hdr["kid"] = kid
+	}
+	header, err := json.Marshal(hdr)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(h, []byte(value))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func tokenHash(alg string) (func() hash.Hash, bool) {
+	switch alg {
+	case TokenHS256:
+		return sha256.New, true
+	case TokenHS512:
+		return sha512.New, true
+	default:
+		return nil, false
+	}
+}
+
+// DecideToken implements TokenAuthorizer.
+//
+// The token must be signed using HS256 or HS512, and expire. The 'exp' and 'nbf' claims are checked
+// with the token leeway, and 'iss' and 'aud' claims if the token issuer and audience are configured.
+// The 'kid' header is the secret version ID, if present only the matching version is checked: it's fetched
+// by its ID if the secret source is a VersionFetcher and a SecretDescriber (see decideVersion).
+// It requires the signatures to be enabled (see AuthorizerConfig.SignatureEnabled).
+func (a *DefaultAuthorizer) DecideToken(ctx context.Context, secretID, token string) (d Decision, err error) {
+	start := time.Now()
+	defer func() {
+		d.Allowed = err == nil
+		d.Latency = time.Since(start)
+	}()
+
+	if !a.cfg.SignatureEnabled {
+		d.Reason = "signature disabled"
+		return d, ErrSignatureDisabled
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		d.Reason = "malformed token"
+		return d, ErrInvalidSecretValue
+	}
+	var (
+		header tokenHeader
+		claims tokenClaims
+	)
+	if err := decodeTokenPart(parts[0], &header); err != nil {
+		d.Reason = "malformed token header"
+		return d, ErrInvalidSecretValue
+	}
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		d.Reason = "malformed token claims"
+		return d, ErrInvalidSecretValue
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		d.Reason = "malformed token signature"
+		return d, ErrInvalidSecretValue
+	}
+	h, ok := tokenHash(header.Alg)
+	if !ok {
+		d.Reason = "unsupported token algorithm"
+		return d, ErrInvalidSecretValue
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Exp == nil:
+		d.Reason = "token without expiration"
+		return d, ErrUnauthorized
+	case now.After(time.Unix(*claims.Exp, 0).Add(a.cfg.TokenLeeway)):
+		d.Reason = "expired token"
+		return d, ErrUnauthorized
+	case claims.Nbf != nil && now.Before(time.Unix(*claims.Nbf, 0).Add(-a.cfg.TokenLeeway)):
+		d.Reason = "token not valid yet"
+		return d, ErrUnauthorized
+	case a.cfg.TokenIssuer != "" && claims.Iss != a.cfg.TokenIssuer:
+		d.Reason = "invalid token issuer"
+		return d, ErrUnauthorized
+	case a.cfg.TokenAudience != "" && !claims.Aud.contains(a.cfg.TokenAudience):
+		d.Reason = "invalid token audience"
+		return d, ErrUnauthorized
+	}
+
+	// the header and the payload are signed as encoded
+	signed := []byte(parts[0] + "." + parts[1])
+	denied := ""
+	match := func(s secret) bool {
+		if header.Kid != "" && header.Kid != s.versionID {
+			return false
+		}
+		name, reason, ok := s.matchValue(nil, func(value string) bool {
+			mac := hmac.New(h, []byte(value))
+			mac.Write(signed)
+			return hmac.Equal(mac.Sum(nil), sig)
+		})
+		if reason != "" {
+			denied = reason
+		}
+		d.KeyName = name
+		return ok
+	}
+	if header.Kid != "" {
+		var reason string
+		addressed := func(versionID string) bool { return versionID == header.Kid }
+		if reason, err = a.decideVersion(ctx, secretID, addressed, true, &d, match); reason != "" {
+			denied = reason
+		}
+	} else {
+		err = a.decide(ctx, secretID, &d, true, match)
+	}
+	if err != nil && denied != "" {
+		d.Reason = denied
+	}
+	return d, err
+}
+
+func decodeTokenPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
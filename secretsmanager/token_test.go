@@ -0,0 +1,197 @@
+package secretsmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+func TestAuthorizer_DecideToken(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+
+	values := map[string]string{
+		VersionCurrent:  "current_value",
+		VersionPrevious: "previous_value",
+	}
+	cli := &MockClient{
+		GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+			stage := aws.ToString(gsvi.VersionStage)
+			if _, ok := values[stage]; !ok {
+				return nil, ErrSecretNotFound
+			}
+			return &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String(values[stage]),
+				VersionId:    aws.String(stage + "_id"),
+				CreatedDate:  aws.Time(time.Now()),
+			}, nil
+		},
+	}
+
+	auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+		ac.SignatureEnabled = true
+		ac.TokenIssuer = "edge"
+		ac.TokenAudience = "api"
+	})
+
+	claims := func(exp time.Duration) map[string]interface{} {
+		return map[string]interface{}{
+			"iss": "edge",
+			"aud": []string{"other", "api"},
+			"exp": time.Now().Add(exp).Unix(),
+		}
+	}
+
+	type tc struct {
+		name   string
+		token  func() string
+		err    error
+		reason string
+		stage  string
+	}
+
+	tcs := []tc{
+		{
+			name: "valid token",
+			token: func() string {
+				tok, _ := SignToken(values[VersionCurrent], TokenHS256, "", claims(time.Minute))
+				return tok
+			},
+			stage: VersionCurrent,
+		},
+		{
+			name: "valid token signed by the previous version",
+			token: func() string {
+				tok, _ := SignToken(values[VersionPrevious], TokenHS512, VersionPrevious+"_id", claims(time.Minute))
+				return tok
+			},
+			stage: VersionPrevious,
+		},
+		{
+			name: "token with mismatching key ID",
+			token: func() string {
+				tok, _ := SignToken(values[VersionCurrent], TokenHS256, VersionPrevious+"_id", claims(time.Minute))
+				return tok
+			},
+			err: ErrUnauthorized,
+		},
+		{
+			name: "expired token",
+			token: func() string {
+				tok, _ := SignToken(values[VersionCurrent], TokenHS256, "", claims(-time.Minute))
+				return tok
+			},
+			err:    ErrUnauthorized,
+			reason: "expired token",
+		},
+		{
+			name: "token with invalid audience",
+			token: func() string {
+				c := claims(time.Minute)
+				c["aud"] = "other"
+				tok, _ := SignToken(values[VersionCurrent], TokenHS256, "", c)
+				return tok
+			},
+			err:    ErrUnauthorized,
+			reason: "invalid token audience",
+		},
+		{
+			name: "token signed by an unknown value",
+			token: func() string {
+				tok, _ := SignToken("unknown_value", TokenHS256, "", claims(time.Minute))
+				return tok
+			},
+			err: ErrUnauthorized,
+		},
+		{
+			name: "unsigned token",
+			token: func() string {
+				return "eyJhbGciOiJub25lIn0.eyJleHAiOjk5OTk5OTk5OTl9."
+			},
+			err:    ErrInvalidSecretValue,
+			reason: "unsupported token algorithm",
+		},
+		{
+			name: "malformed token",
+			token: func() string {
+				return "not_a_token"
+			},
+			err: ErrInvalidSecretValue,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := auth.DecideToken(ctx, secret, tc.token())
+			if tc.err != nil {
+				if want, got := tc.err, err; !errors.Is(got, want) {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+				if tc.reason != "" && tc.reason != d.Reason {
+					t.Fatalf("expect %v, %v be equals", tc.reason, d.Reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+			if want, got := tc.stage, d.Stage; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		})
+	}
+	t.Run("with key ID of an old version", func(t *testing.T) {
+		versions := map[string]string{
+			"current_id":  "current_value",
+			"previous_id": "previous_value",
+		}
+		stageCalls, versionCalls := 0, 0
+		cli := &MockClient{
+			DescribeSecretFunc: func(ctx context.Context, dsi *secretsmanager.DescribeSecretInput, f ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+				return &secretsmanager.DescribeSecretOutput{
+					VersionIdsToStages: map[string][]string{
+						"current_id":  {VersionCurrent},
+						"previous_id": {VersionPrevious},
+					},
+					LastRotatedDate: aws.Time(time.Now().Add(-time.Minute)),
+				}, nil
+			},
+			GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+				if gsvi.VersionStage != nil {
+					stageCalls++
+					return nil, ErrSecretNotFound
+				}
+				versionCalls++
+				versionID := aws.ToString(gsvi.VersionId)
+				return &secretsmanager.GetSecretValueOutput{
+					SecretString: aws.String(versions[versionID]),
+					VersionId:    aws.String(versionID),
+					CreatedDate:  aws.Time(time.Now().Add(-time.Hour)),
+				}, nil
+			},
+		}
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.SignatureEnabled = true
+		})
+
+		tok, _ := SignToken(versions["previous_id"], TokenHS256, "previous_id", claims(time.Minute))
+		d, err := auth.DecideToken(ctx, secret, tok)
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := VersionPrevious, d.Stage; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		// the addressed version is fetched by its ID, without fetching the stages
+		if want, got := 1, versionCalls; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if want, got := 0, stageCalls; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+}
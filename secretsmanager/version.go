@@ -0,0 +1,79 @@
+package secretsmanager
+
+import (
+	"context"
+	"errors"
+)
+
+// decideVersion checks the secret version addressed by the value, i.e. a key version hint
+// (see AuthorizerConfig.VersionAddressed) or a token 'kid' header, against the match function. The version
+// stage is read from the secret description: CURRENT is always checked, PREVIOUS and PENDING only within
+// the grace window. Versions are immutable, so a cached version is never fetched again.
+//
+// It returns the reason of the denial if the version is either unknown or not allowed, such denials are not
+// black listed. It falls back to decide if the version stages are not available.
+func (a *DefaultAuthorizer) decideVersion(ctx context.Context, secretID string, addressed func(versionID string) bool, throttled bool, d *Decision, matchFn func(s secret) bool) (string, error) {
+	if _, ok := a.source.(VersionFetcher); !ok {
+		return "", a.decide(ctx, secretID, d, throttled, matchFn)
+	}
+
+	cur := a.janitor.getStage(secretID, VersionCurrent)
+	desc, ok := a.describe(ctx, secretID, cur, d)
+	if !ok || len(desc.VersionStages) == 0 {
+		return "", a.decide(ctx, secretID, d, throttled, matchFn)
+	}
+
+	versionID, stage := "", ""
+	for id, stages := range desc.VersionStages {
+		if !addressed(id) {
+			continue
+		}
+		versionID = id
+		for _, st := range []string{VersionCurrent, VersionPrevious, VersionPending} {
+			if hasStage(stages, st) {
+				stage = st
+				break
+			}
+		}
+		if stage != "" {
+			break
+		}
+	}
+	switch {
+	case versionID == "":
+		return "unknown key version", ErrUnauthorized
+	case stage == "":
+		return "deprecated key version", ErrUnauthorized
+	case stage != VersionCurrent:
+		previous, pending := a.graceWindow(ctx, secretID, cur, d)
+		if (stage == VersionPrevious && !previous) || (stage == VersionPending && !pending) {
+			return "key version out of grace window", ErrUnauthorized
+		}
+	}
+
+	s := a.janitor.getStage(secretID, stage)
+	if s.versionID != versionID {
+		var (
+			called bool
+			err    error
+		)
+		s, called, err = a.fetch(ctx, secretID, stage, versionID)
+		if called {
+			d.RemoteCalls++
+		}
+		if err != nil {
+			if !errors.Is(err, ErrSecretNotFound) && !errors.Is(err, ErrInvalidSecret) && a.cfg.Availability == FailOpen {
+				d.FailedOpen, d.SourceErr = true, err
+				return "", nil
+			}
+			d.Reason = "key version fetch failed"
+			return "", err
+		}
+	}
+	if !matchFn(s) {
+		d.Reason = "no matching secret version"
+		return "", ErrUnauthorized
+	}
+	d.Stage, d.VersionID, d.Cached = stage, versionID, d.RemoteCalls == 0
+	return "", nil
+}
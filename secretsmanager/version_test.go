@@ -0,0 +1,186 @@
+package secretsmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+func TestAuthorizer_VersionAddressed(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+
+	keys := map[string]string{
+		"current_id":  FormatKey("current_id", "4Hq9mZt1b0X3kR7uWc2yPn8sLd5vGe6j"),
+		"previous_id": FormatKey("previous_id", "Zt1b0X3kR7uWc2yPn8sLd5vGe6j4Hq9m"),
+		"old_id":      FormatKey("old_id", "R7uWc2yPn8sLd5vGe6j4Hq9mZt1b0X3k"),
+	}
+	stageCalls, versionCalls := 0, 0
+	cli := &MockClient{
+		DescribeSecretFunc: func(ctx context.Context, dsi *secretsmanager.DescribeSecretInput, f ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+			return &secretsmanager.DescribeSecretOutput{
+				VersionIdsToStages: map[string][]string{
+					"current_id":  {VersionCurrent},
+					"previous_id": {VersionPrevious},
+					"old_id":      {},
+				},
+				LastRotatedDate: aws.Time(time.Now().Add(-time.Minute)),
+			}, nil
+		},
+		GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+			if gsvi.VersionStage != nil {
+				stageCalls++
+			}
+			versionID := aws.ToString(gsvi.VersionId)
+			if _, ok := keys[versionID]; !ok {
+				return nil, &types.ResourceNotFoundException{}
+			}
+			versionCalls++
+			return &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String(keys[versionID]),
+				VersionId:    aws.String(versionID),
+				CreatedDate:  aws.Time(time.Now().Add(-time.Hour)),
+			}, nil
+		},
+	}
+
+	auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+		ac.KeyFormat = true
+		ac.VersionAddressed = true
+	})
+
+	t.Run("current key", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			d, err := auth.Decide(ctx, secret, keys["current_id"])
+			if err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+			if want, got := VersionCurrent, d.Stage; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		}
+		// the version is fetched once
+		if want, got := 1, versionCalls; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("previous key within the grace window", func(t *testing.T) {
+		d, err := auth.Decide(ctx, secret, keys["previous_id"])
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := VersionPrevious, d.Stage; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	type tc struct {
+		name        string
+		value       string
+		reason      string
+		blackListed bool
+	}
+
+	tcs := []tc{
+		{
+			name:   "deprecated key",
+			value:  keys["old_id"],
+			reason: "deprecated key version",
+		},
+		{
+			name:   "unknown key version",
+			value:  FormatKey("unknown_id", "4Hq9mZt1b0X3kR7uWc2yPn8sLd5vGe6j"),
+			reason: "unknown key version",
+		},
+		{
+			name:        "forged key of a known version",
+			value:       FormatKey("current_id", "Pn8sLd5vGe6j4Hq9mZt1b0X3kR7uWc2y"),
+			reason:      "no matching secret version",
+			blackListed: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			versionCalls = 0
+			d, err := auth.Decide(ctx, secret, tc.value)
+			if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := tc.reason, d.Reason; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := 0, versionCalls; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := tc.blackListed, auth.blackList(secret).contains(auth.janitor.digest(tc.value)); want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		})
+	}
+
+	// the secret stages are never tried in turn
+	if want, got := 0, stageCalls; want != got {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+}
+
+func TestAuthorizer_VersionAddressedKeyring(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+
+	partner := FormatKey("current_id", "Ld5vGe6j4Hq9mZt1b0X3kR7uWc2yPn8s")
+	current := `{"keys":{"cloudfront":{"value":"` + FormatKey("current_id", "4Hq9mZt1b0X3kR7uWc2yPn8sLd5vGe6j") + `"},"partner":{"value":"` + partner + `"}}}`
+	st := newRotationState(current, "", "")
+
+	// only the cloudfront key is rotated, twice
+	rotator := NewDefaultRotator(st.client("Zt1b0X3kR7uWc2yPn8sLd5vGe6j4Hq9m"), func(rc *RotatorConfig) {
+		rc.KeyringKey = "cloudfront"
+		rc.KeyFormat = true
+	})
+	for _, token := range []string{"token_1", "token_2"} {
+		st.versions[token] = &rotationVersion{stages: []string{VersionPending}}
+		if err := rotator.Create(ctx, secret, token); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if err := rotator.Finish(ctx, secret, token); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+	}
+	k, err := ParseKeyring(aws.ToString(st.versions["token_2"].value))
+	if err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+
+	auth := NewAuthorizer(st.client(""), NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+		ac.Keyring = true
+		ac.KeyFormat = true
+		ac.VersionAddressed = true
+	})
+
+	for name, value := range map[string]string{
+		// the rotated key holds the hint of the current version
+		"cloudfront": k.Keys["cloudfront"].Value,
+		// the partner key still holds the hint of the first version
+		"partner": partner,
+	} {
+		t.Run(name, func(t *testing.T) {
+			d, err := auth.Decide(ctx, secret, value)
+			if err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+			if want, got := VersionCurrent, d.Stage; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := name, d.KeyName; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package secretsmanager
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookAuthorizer presents a service that checks whether a webhook request is signed using a secret value,
+// and describes how the decision was made.
+// The returned error is nil only if the request is authorized.
+type WebhookAuthorizer interface {
+	DecideWebhook(ctx context.Context, secretID string, v WebhookVerifier, r WebhookRequest) (Decision, error)
+}
+
+// WebhookRequest holds the parts of a webhook request used to verify its signature.
+type WebhookRequest struct {
+	Header http.Header
+	Body   []byte
+}
+
+// WebhookVerifier reproduces the signature scheme of a webhook provider.
+type WebhookVerifier interface {
+	// Name returns the provider name.
+	Name() string
+
+	// Prepare checks the signature headers of the given request, and returns the function
+	// that reports whether the signature is made using a secret value, and returns the matched signature.
+	// It fails with ErrInvalidSecretValue if the headers are missing or malformed,
+	// and ErrUnauthorized if the signature timestamp is out of tolerance.
+	Prepare(r WebhookRequest) (match func(value string) (sig []byte, ok bool), err error)
+}
+
+// Webhook provider names.
+const (
+	WebhookGitHub = "github"
+	WebhookStripe = "stripe"
+	WebhookSlack  = "slack"
+)
+
+// WebhookPreset returns the verifier of the given provider.
+// The tolerance is the maximum age of the signature timestamp, if the provider scheme has one.
+func WebhookPreset(name string, tolerance time.Duration) (WebhookVerifier, error) {
+	switch name {
+	case WebhookGitHub:
+		return GitHubVerifier{}, nil
+	case WebhookStripe:
+		return StripeVerifier{Tolerance: tolerance}, nil
+	case WebhookSlack:
+		return SlackVerifier{Tolerance: tolerance}, nil
+	default:
+		return nil, fmt.Errorf("unknown webhook preset: %s", name)
+	}
+}
+
+// GitHubVerifier verifies the GitHub 'X-Hub-Signature-256' header:
+// 'sha256=' followed by the hex encoded HMAC-SHA256 of the body.
+type GitHubVerifier struct{}
+
+var _ WebhookVerifier = GitHubVerifier{}
+
+// Name implements WebhookVerifier.
+func (GitHubVerifier) Name() string { return WebhookGitHub }
+
+// Prepare implements WebhookVerifier.
+func (GitHubVerifier) Prepare(r WebhookRequest) (func(string) ([]byte, bool), error) {
+	hexSig, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok {
+		return nil, fmt.Errorf("%w: missing or malformed X-Hub-Signature-256 header", ErrInvalidSecretValue)
+	}
+	sig, ok := hexSignature(hexSig)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing or malformed X-Hub-Signature-256 header", ErrInvalidSecretValue)
+	}
+	return func(value string) ([]byte, bool) {
+		return sig, hmac.Equal(hmacSHA256(value, r.Body), sig)
+	}, nil
+}
+
+// StripeVerifier verifies the Stripe 'Stripe-Signature' header: 't=<timestamp>,v1=<signature>[,v1=...]',
+// each signature is the hex encoded HMAC-SHA256 of '<timestamp>.<body>'.
+type StripeVerifier struct {
+	Tolerance time.Duration
+}
+
+var _ WebhookVerifier = StripeVerifier{}
+
+// Name implements WebhookVerifier.
+func (StripeVerifier) Name() string { return WebhookStripe }
+
+// Prepare implements WebhookVerifier.
+func (v StripeVerifier) Prepare(r WebhookRequest) (func(string) ([]byte, bool), error) {
+	var (
+		ts   string
+		sigs [][]byte
+	)
+	for _, part := range strings.Split(r.Header.Get("Stripe-Signature"), ",") {
+		k, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = val
+		case "v1":
+			if sig, ok := hexSignature(val); ok {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	if ts == "" || len(sigs) == 0 {
+		return nil, fmt.Errorf("%w: missing or malformed Stripe-Signature header", ErrInvalidSecretValue)
+	}
+	if err := checkTimestamp(ts, v.Tolerance); err != nil {
+		return nil, err
+	}
+
+	payload := append([]byte(ts+"."), r.Body...)
+	return func(value string) ([]byte, bool) {
+		mac := hmacSHA256(value, payload)
+		for _, sig := range sigs {
+			if hmac.Equal(mac, sig) {
+				return sig, true
+			}
+		}
+		return nil, false
+	}, nil
+}
+
+// SlackVerifier verifies the Slack 'X-Slack-Signature' header: 'v0=' followed by
+// the hex encoded HMAC-SHA256 of 'v0:<timestamp>:<body>', the timestamp is held by 'X-Slack-Request-Timestamp'.
+type SlackVerifier struct {
+	Tolerance time.Duration
+}
+
+var _ WebhookVerifier = SlackVerifier{}
+
+// Name implements WebhookVerifier.
+func (SlackVerifier) Name() string { return WebhookSlack }
+
+// Prepare implements WebhookVerifier.
+func (v SlackVerifier) Prepare(r WebhookRequest) (func(string) ([]byte, bool), error) {
+	ts := r.Header.Get("X-Slack-Request-Timestamp")
+	sig, ok := hexSignature(strings.TrimPrefix(r.Header.Get("X-Slack-Signature"), "v0="))
+	if ts == "" || !ok {
+		return nil, fmt.Errorf("%w: missing or malformed X-Slack-Signature headers", ErrInvalidSecretValue)
+	}
+	if err := checkTimestamp(ts, v.Tolerance); err != nil {
+		return nil, err
+	}
+
+	payload := append([]byte("v0:"+ts+":"), r.Body...)
+	return func(value string) ([]byte, bool) {
+		return sig, hmac.Equal(hmacSHA256(value, payload), sig)
+	}, nil
+}
+
+func hmacSHA256(value string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(value))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func hexSignature(s string) ([]byte, bool) {
+	if s == "" {
+		return nil, false
+	}
+	sig, err := hex.DecodeString(s)
+	return sig, err == nil
+}
+
+// checkTimestamp fails if the given Unix timestamp is older than the tolerance, or as much in the future.
+// A zero tolerance disables the check.
+func checkTimestamp(ts string, tolerance time.Duration) error {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid signature timestamp", ErrInvalidSecretValue)
+	}
+	if tolerance <= 0 {
+		return nil
+	}
+	if age := time.Since(time.Unix(sec, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: signature timestamp out of tolerance", ErrUnauthorized)
+	}
+	return nil
+}
+
+// DecideWebhook implements WebhookAuthorizer.
+//
+// The signature is checked against the same secret stages as Decide, so that webhook secrets
+// can be rotated. It requires the signatures to be enabled (see AuthorizerConfig.SignatureEnabled).
+// Verified signatures are recorded as nonces, a replayed delivery is rejected during twice the clock skew,
+// and a delivery whose signature can't be recorded is denied.
+func (a *DefaultAuthorizer) DecideWebhook(ctx context.Context, secretID string, v WebhookVerifier, r WebhookRequest) (d Decision, err error) {
+	start := time.Now()
+	defer func() {
+		d.Allowed = err == nil
+		d.Latency = time.Since(start)
+	}()
+
+	if !a.cfg.SignatureEnabled {
+		d.Reason = "signature disabled"
+		return d, ErrSignatureDisabled
+	}
+	if a.cfg.ClockSkew <= 0 || a.cfg.NonceCapacity <= 0 {
+		d.Reason = "replay protection disabled"
+		return d, ErrReplayUnprotected
+	}
+	match, err := v.Prepare(r)
+	if err != nil {
+		d.Reason = "invalid " + v.Name() + " webhook signature"
+		return d, err
+	}
+
+	// the matched signature is kept to be recorded as a nonce
+	denied, matched := "", []byte(nil)
+	if err := a.decide(ctx, secretID, &d, true, func(s secret) bool {
+		name, reason, ok := s.matchValue(nil, func(value string) bool {
+			sig, ok := match(value)
+			if ok {
+				matched = sig
+			}
+			return ok
+		})
+		if reason != "" {
+			denied = reason
+		}
+		d.KeyName = name
+		return ok
+	}); err != nil {
+		if denied != "" {
+			d.Reason = denied
+		}
+		return d, err
+	}
+
+	// a failed open decision has no verified signature to record
+	if matched == nil {
+		return d, nil
+	}
+	if err := a.recordNonce(secretID, a.janitor.digest(v.Name()+":"+string(matched)), "replayed webhook delivery", &d); err != nil {
+		return d, err
+	}
+	return d, nil
+}
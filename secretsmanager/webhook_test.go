@@ -0,0 +1,211 @@
+package secretsmanager
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+func TestWebhookPresets(t *testing.T) {
+	value := "whsec_value"
+	body := []byte(`{"id":"evt_1"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	sign := func(payload string) string {
+		return hex.EncodeToString(hmacSHA256(value, []byte(payload)))
+	}
+
+	type tc struct {
+		name    string
+		preset  string
+		header  http.Header
+		err     error
+		matched bool
+	}
+
+	tcs := []tc{
+		{
+			name:    "github",
+			preset:  WebhookGitHub,
+			header:  http.Header{"X-Hub-Signature-256": {"sha256=" + sign(string(body))}},
+			matched: true,
+		},
+		{
+			name:   "github with wrong signature",
+			preset: WebhookGitHub,
+			header: http.Header{"X-Hub-Signature-256": {"sha256=" + sign("other")}},
+		},
+		{
+			name:   "github without prefix",
+			preset: WebhookGitHub,
+			header: http.Header{"X-Hub-Signature-256": {sign(string(body))}},
+			err:    ErrInvalidSecretValue,
+		},
+		{
+			name:   "github with missing header",
+			preset: WebhookGitHub,
+			header: http.Header{},
+			err:    ErrInvalidSecretValue,
+		},
+		{
+			name:    "stripe",
+			preset:  WebhookStripe,
+			header:  http.Header{"Stripe-Signature": {"t=" + now + ",v1=" + sign("other") + ",v1=" + sign(now+"."+string(body)) + ",v0=abcd"}},
+			matched: true,
+		},
+		{
+			name:   "stripe with expired timestamp",
+			preset: WebhookStripe,
+			header: http.Header{"Stripe-Signature": {"t=" + old + ",v1=" + sign(old+"."+string(body))}},
+			err:    ErrUnauthorized,
+		},
+		{
+			name:   "slack",
+			preset: WebhookSlack,
+			header: http.Header{
+				"X-Slack-Request-Timestamp": {now},
+				"X-Slack-Signature":         {"v0=" + sign("v0:"+now+":"+string(body))},
+			},
+			matched: true,
+		},
+		{
+			name:   "slack with missing timestamp",
+			preset: WebhookSlack,
+			header: http.Header{"X-Slack-Signature": {"v0=" + sign("v0:"+now+":"+string(body))}},
+			err:    ErrInvalidSecretValue,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := WebhookPreset(tc.preset, 5*time.Minute)
+			if err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+			match, err := v.Prepare(WebhookRequest{Header: tc.header, Body: body})
+			if tc.err != nil {
+				if want, got := tc.err, err; !errors.Is(got, want) {
+					t.Fatalf("expect %v, %v be equals", want, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+			if _, got := match(value); tc.matched != got {
+				t.Fatalf("expect %v, %v be equals", tc.matched, got)
+			}
+		})
+	}
+
+	if _, err := WebhookPreset("unknown", 0); err == nil {
+		t.Fatal("expect err be not nil")
+	}
+}
+
+func TestAuthorizer_DecideWebhook(t *testing.T) {
+	ctx := context.Background()
+	secret := "arn:aws:secretsmanager:eu-west-1:19cx3122:secret/fake"
+
+	values := map[string]string{
+		VersionCurrent:  "current_value",
+		VersionPrevious: "previous_value",
+	}
+	cli := &MockClient{
+		GetSecretValueFunc: func(ctx context.Context, gsvi *secretsmanager.GetSecretValueInput, f ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+			stage := aws.ToString(gsvi.VersionStage)
+			if _, ok := values[stage]; !ok {
+				return nil, ErrSecretNotFound
+			}
+			return &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String(values[stage]),
+				VersionId:    aws.String(stage + "_id"),
+				CreatedDate:  aws.Time(time.Now()),
+			}, nil
+		},
+	}
+
+	body := []byte(`{"action":"opened"}`)
+	request := func(value string) WebhookRequest {
+		return WebhookRequest{
+			Header: http.Header{"X-Hub-Signature-256": {"sha256=" + hex.EncodeToString(hmacSHA256(value, body))}},
+			Body:   body,
+		}
+	}
+
+	t.Run("with signature disabled", func(t *testing.T) {
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute))
+
+		_, err := auth.DecideWebhook(ctx, secret, GitHubVerifier{}, request(values[VersionCurrent]))
+		if want, got := ErrSignatureDisabled, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with replay protection disabled", func(t *testing.T) {
+		auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+			ac.SignatureEnabled = true
+			ac.ClockSkew = 0
+		})
+
+		_, err := auth.DecideWebhook(ctx, secret, GitHubVerifier{}, request(values[VersionCurrent]))
+		if want, got := ErrReplayUnprotected, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	auth := NewAuthorizer(cli, NewJanitor(time.Minute), func(ac *AuthorizerConfig) {
+		ac.SignatureEnabled = true
+	})
+
+	t.Run("with rotated secret", func(t *testing.T) {
+		// the previous value is accepted within the grace period
+		d, err := auth.DecideWebhook(ctx, secret, GitHubVerifier{}, request(values[VersionPrevious]))
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := VersionPrevious, d.Stage; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+
+		d, err = auth.DecideWebhook(ctx, secret, GitHubVerifier{}, request(values[VersionCurrent]))
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := VersionCurrent, d.Stage; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with invalid signature", func(t *testing.T) {
+		_, err := auth.DecideWebhook(ctx, secret, GitHubVerifier{}, request("invalid_value"))
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+	t.Run("with replayed delivery", func(t *testing.T) {
+		r := WebhookRequest{
+			Header: http.Header{"X-Hub-Signature-256": {"sha256=" + hex.EncodeToString(hmacSHA256(values[VersionCurrent], []byte(`{"action":"closed"}`)))}},
+			Body:   []byte(`{"action":"closed"}`),
+		}
+		if _, err := auth.DecideWebhook(ctx, secret, GitHubVerifier{}, r); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+
+		d, err := auth.DecideWebhook(ctx, secret, GitHubVerifier{}, r)
+		if want, got := ErrUnauthorized, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if want, got := "replayed webhook delivery", d.Reason; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package ssm
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+type ClientAPI interface {
+	GetParameterHistory(
+		ctx context.Context, params *ssm.GetParameterHistoryInput, optFns ...func(*ssm.Options),
+	) (*ssm.GetParameterHistoryOutput, error)
+}
+
+var _ ClientAPI = &ssm.Client{}
+
+// NewClient return a ssm client
+func NewClient(cfg aws.Config, endpoint string) ClientAPI {
+	svc := ssm.NewFromConfig(cfg,
+		func(o *ssm.Options) {
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		},
+	)
+
+	return svc
+}
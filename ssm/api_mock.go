@@ -0,0 +1,25 @@
+package ssm
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+type MockClient struct {
+	GetParameterHistoryFunc func(
+		ctx context.Context, params *ssm.GetParameterHistoryInput, optFns ...func(*ssm.Options),
+	) (*ssm.GetParameterHistoryOutput, error)
+}
+
+var _ ClientAPI = &MockClient{}
+
+// GetParameterHistory implements ClientAPI.
+func (m *MockClient) GetParameterHistory(
+	ctx context.Context, params *ssm.GetParameterHistoryInput, optFns ...func(*ssm.Options),
+) (*ssm.GetParameterHistoryOutput, error) {
+	if m.GetParameterHistoryFunc != nil {
+		return m.GetParameterHistoryFunc(ctx, params, optFns...)
+	}
+	return nil, nil
+}
@@ -0,0 +1,178 @@
+package ssm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/ln80/secure-lambda-url/secretsmanager"
+	"golang.org/x/sync/singleflight"
+)
+
+type SourceConfig struct {
+	// CurrentLabel, PreviousLabel and PendingLabel are the parameter version labels of the secret stages.
+	// SSM rejects labels beginning with 'aws' or 'ssm', so they can't be the secretsmanager stage names.
+	CurrentLabel  string
+	PreviousLabel string
+	PendingLabel  string
+
+	// HistoryTTL is the period during which a fetched parameter history is reused by the other stages,
+	// so that checking the stages of a secret costs a single GetParameterHistory call.
+	HistoryTTL time.Duration
+}
+
+// Source implements secretsmanager.SecretSource on top of SSM Parameter Store SecureString parameters.
+//
+// Secret stages are resolved using the parameter history: a stage is the version labeled by the stage label
+// (i.e. 'current' for AWSCURRENT). If none of the parameter versions is labeled, AWSCURRENT and AWSPREVIOUS
+// are respectively the latest and the second latest versions, and AWSPENDING doesn't exist.
+type Source struct {
+	client ClientAPI
+	cfg    *SourceConfig
+
+	group     singleflight.Group
+	historyMu sync.Mutex
+	histories map[string]history
+}
+
+// history is a fetched parameter history.
+type history struct {
+	versions  []types.ParameterHistory
+	fetchedAt time.Time
+}
+
+var _ secretsmanager.SecretSource = &Source{}
+
+func NewSource(cli ClientAPI, opts ...func(*SourceConfig)) *Source {
+	cfg := &SourceConfig{
+		CurrentLabel:  "current",
+		PreviousLabel: "previous",
+		PendingLabel:  "pending",
+		HistoryTTL:    time.Second,
+	}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		opt(cfg)
+	}
+
+	return &Source{
+		client:    cli,
+		cfg:       cfg,
+		histories: make(map[string]history),
+	}
+}
+
+// Fetch implements secretsmanager.SecretSource.
+func (s *Source) Fetch(ctx context.Context, secretID, stage string) (secretsmanager.SecretValue, error) {
+	versions, err := s.history(ctx, secretID)
+	if err != nil {
+		return secretsmanager.SecretValue{}, err
+	}
+
+	want := map[string]string{
+		secretsmanager.VersionCurrent:  s.cfg.CurrentLabel,
+		secretsmanager.VersionPrevious: s.cfg.PreviousLabel,
+		secretsmanager.VersionPending:  s.cfg.PendingLabel,
+	}[stage]
+
+	// history is sorted by descending version
+	labeled := false
+	for _, p := range versions {
+		for _, label := range p.Labels {
+			if label == want {
+				return valueOf(p), nil
+			}
+			if label == s.cfg.CurrentLabel ||
+				label == s.cfg.PreviousLabel ||
+				label == s.cfg.PendingLabel {
+				labeled = true
+			}
+		}
+	}
+
+	if !labeled {
+		switch {
+		case stage == secretsmanager.VersionCurrent && len(versions) > 0:
+			return valueOf(versions[0]), nil
+		case stage == secretsmanager.VersionPrevious && len(versions) > 1:
+			return valueOf(versions[1]), nil
+		}
+	}
+
+	return secretsmanager.SecretValue{}, fmt.Errorf("%w: %s %s", secretsmanager.ErrSecretNotFound, secretID, stage)
+}
+
+// history returns the parameter versions sorted by descending version number. A history fetched within
+// the history TTL is reused, and concurrent fetches of the same parameter are coalesced into a single call.
+func (s *Source) history(ctx context.Context, name string) ([]types.ParameterHistory, error) {
+	s.historyMu.Lock()
+	h, ok := s.histories[name]
+	s.historyMu.Unlock()
+	if ok && time.Since(h.fetchedAt) < s.cfg.HistoryTTL {
+		return h.versions, nil
+	}
+
+	v, err, _ := s.group.Do(name, func() (interface{}, error) {
+		versions, err := s.fetchHistory(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		s.historyMu.Lock()
+		s.histories[name] = history{versions: versions, fetchedAt: time.Now()}
+		s.historyMu.Unlock()
+		return versions, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]types.ParameterHistory), nil
+}
+
+// fetchHistory gets the decrypted parameter versions sorted by descending version number.
+func (s *Source) fetchHistory(ctx context.Context, name string) ([]types.ParameterHistory, error) {
+	history := []types.ParameterHistory{}
+
+	var nextToken *string
+	for {
+		out, err := s.client.GetParameterHistory(ctx, &ssm.GetParameterHistoryInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(true),
+			NextToken:      nextToken,
+		})
+		if err != nil {
+			var te *types.ParameterNotFound
+			if errors.As(err, &te) {
+				return nil, fmt.Errorf("%w: %v", secretsmanager.ErrSecretNotFound, err)
+			}
+			return nil, err
+		}
+		history = append(history, out.Parameters...)
+
+		if nextToken = out.NextToken; aws.ToString(nextToken) == "" {
+			break
+		}
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Version > history[j].Version
+	})
+
+	return history, nil
+}
+
+func valueOf(p types.ParameterHistory) secretsmanager.SecretValue {
+	return secretsmanager.SecretValue{
+		Value:     aws.ToString(p.Value),
+		VersionID: strconv.FormatInt(p.Version, 10),
+		CreatedAt: aws.ToTime(p.LastModifiedDate),
+	}
+}
@@ -0,0 +1,145 @@
+package ssm
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/ln80/secure-lambda-url/secretsmanager"
+)
+
+func TestSource(t *testing.T) {
+	ctx := context.Background()
+	param := "/secure-lambda-url/key"
+
+	t.Run("with missing parameter", func(t *testing.T) {
+		cli := &MockClient{
+			GetParameterHistoryFunc: func(ctx context.Context, params *ssm.GetParameterHistoryInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterHistoryOutput, error) {
+				return nil, &types.ParameterNotFound{}
+			},
+		}
+
+		_, err := NewSource(cli).Fetch(ctx, param, secretsmanager.VersionCurrent)
+		if want, got := secretsmanager.ErrSecretNotFound, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with unlabeled versions", func(t *testing.T) {
+		pages := [][]types.ParameterHistory{
+			{{Version: 1, Value: aws.String("v1")}, {Version: 2, Value: aws.String("v2")}},
+			{{Version: 3, Value: aws.String("v3")}},
+		}
+		cli := &MockClient{
+			GetParameterHistoryFunc: func(ctx context.Context, params *ssm.GetParameterHistoryInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterHistoryOutput, error) {
+				if !aws.ToBool(params.WithDecryption) {
+					t.Fatal("expect parameter be decrypted")
+				}
+				page := 0
+				if params.NextToken != nil {
+					page, _ = strconv.Atoi(*params.NextToken)
+				}
+				out := &ssm.GetParameterHistoryOutput{Parameters: pages[page]}
+				if page+1 < len(pages) {
+					out.NextToken = aws.String(strconv.Itoa(page + 1))
+				}
+				return out, nil
+			},
+		}
+		src := NewSource(cli)
+
+		for stage, want := range map[string]string{
+			secretsmanager.VersionCurrent:  "v3",
+			secretsmanager.VersionPrevious: "v2",
+		} {
+			v, err := src.Fetch(ctx, param, stage)
+			if err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+			if got := v.Value; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		}
+
+		_, err := src.Fetch(ctx, param, secretsmanager.VersionPending)
+		if want, got := secretsmanager.ErrSecretNotFound, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with labeled versions", func(t *testing.T) {
+		calls := 0
+		cli := &MockClient{
+			GetParameterHistoryFunc: func(ctx context.Context, params *ssm.GetParameterHistoryInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterHistoryOutput, error) {
+				calls++
+				return &ssm.GetParameterHistoryOutput{
+					Parameters: []types.ParameterHistory{
+						{Version: 1, Value: aws.String("v1"), Labels: []string{"previous"}},
+						{Version: 2, Value: aws.String("v2"), Labels: []string{"current"}},
+						{Version: 3, Value: aws.String("v3"), Labels: []string{"pending"}},
+					},
+				}, nil
+			},
+		}
+		src := NewSource(cli, func(sc *SourceConfig) {
+			sc.HistoryTTL = time.Minute
+		})
+
+		for stage, want := range map[string]string{
+			secretsmanager.VersionCurrent:  "v2",
+			secretsmanager.VersionPrevious: "v1",
+			secretsmanager.VersionPending:  "v3",
+		} {
+			v, err := src.Fetch(ctx, param, stage)
+			if err != nil {
+				t.Fatalf("expect err be nil, got %v", err)
+			}
+			if got := v.Value; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if v.VersionID == "" {
+				t.Fatal("expect version ID be not empty")
+			}
+		}
+
+		// the history is fetched once for all the stages
+		if want, got := 1, calls; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with custom labels", func(t *testing.T) {
+		cli := &MockClient{
+			GetParameterHistoryFunc: func(ctx context.Context, params *ssm.GetParameterHistoryInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterHistoryOutput, error) {
+				return &ssm.GetParameterHistoryOutput{
+					Parameters: []types.ParameterHistory{
+						{Version: 1, Value: aws.String("v1"), Labels: []string{"live"}},
+						{Version: 2, Value: aws.String("v2"), Labels: []string{"current"}},
+					},
+				}, nil
+			},
+		}
+		src := NewSource(cli, func(sc *SourceConfig) {
+			sc.CurrentLabel = "live"
+		})
+
+		v, err := src.Fetch(ctx, param, secretsmanager.VersionCurrent)
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := "v1", v.Value; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+
+		// the default label isn't a stage label anymore, and the versions are labeled
+		_, err = src.Fetch(ctx, param, secretsmanager.VersionPrevious)
+		if want, got := secretsmanager.ErrSecretNotFound, err; !errors.Is(got, want) {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+}
@@ -1,8 +1,9 @@
 module github.com/ln80/secure-lambda-url/stack/extension
 
-go 1.19
+go 1.21
 
 require (
+	github.com/aws/aws-lambda-go v1.41.0
 	github.com/aws/aws-sdk-go-v2/config v1.18.33
 	github.com/aws/aws-sdk-go-v2/service/secretsmanager v1.21.0
 )
@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"errors"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -13,9 +16,31 @@ import (
 	"github.com/prozz/aws-embedded-metrics-golang/emf"
 )
 
+// maxWebhookBodySize is the Function URL request payload quota.
+const maxWebhookBodySize = 6 << 20
+
+// keyNameHeader is the response header holding the name of the matching keyring key.
+const keyNameHeader = "X-Key-Name"
+
 // MakeHandler returns the http.Handler used by the sidecar process.
 // Lambda handler will issue HTTP Get requests to this server for API key validation.
-func MakeHandler(secretID, token string, auth secretsmanager.Authorizer) http.Handler {
+// The optional 'secret' query parameter selects one of the configured secrets.
+//
+// Signed requests are validated instead if the 'signature' query parameter is present, the other canonical
+// request parts are passed using the 'method', 'path', 'query', 'body_hash', 'timestamp' and 'nonce' parameters.
+// It requires the authorizer to be a secretsmanager.SignatureAuthorizer.
+//
+// For keyring secrets, the optional 'method' and 'path' query parameters are the scope of the key, and the name
+// of the matching key is returned in the 'X-Key-Name' response header.
+// It requires the authorizer to be a secretsmanager.KeyringAuthorizer, otherwise the scope is ignored.
+//
+// Tokens are validated instead if the 'token' query parameter is present.
+// It requires the authorizer to be a secretsmanager.TokenAuthorizer.
+//
+// Webhook requests are validated using HTTP Post requests, carrying the webhook body and signature headers,
+// if a webhook provider is configured for the selected secret.
+// It requires the authorizer to be a secretsmanager.WebhookAuthorizer.
+func MakeHandler(router *secretRouter, token string, auth secretsmanager.DecisionAuthorizer) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		m := emf.New().
@@ -23,7 +48,7 @@ func MakeHandler(secretID, token string, auth secretsmanager.Authorizer) http.Ha
 		// Dimension("lambdaFunction", os.Getenv("AWS_LAMBDA_FUNCTION_NAME"))
 		defer m.Log()
 
-		if r.Method != http.MethodGet || r.URL.Path != "/" {
+		if (r.Method != http.MethodGet && r.Method != http.MethodPost) || r.URL.Path != "/" {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			m.Metric("BadRequest", 1)
 			return
@@ -34,27 +59,138 @@ func MakeHandler(secretID, token string, auth secretsmanager.Authorizer) http.Ha
 			return
 		}
 
-		k := strings.TrimSpace(r.URL.Query().Get("key"))
+		secretID, ok := router.secret(r.URL.Query().Get("secret"))
+		if !ok {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			m.Metric("BadRequestCount", 1)
+			return
+		}
 
-		err, remoteCalled := auth.Authorize(r.Context(), secretID, k)
-		if remoteCalled {
-			m.Metric("SecretRequestCount", 1)
+		var (
+			d   secretsmanager.Decision
+			err error
+		)
+		if r.Method == http.MethodPost {
+			v, ok := router.webhook(secretID)
+			whAuth, authOk := auth.(secretsmanager.WebhookAuthorizer)
+			if !ok || !authOk {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				m.Metric("BadRequestCount", 1)
+				return
+			}
+			body, rerr := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+			if rerr != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				m.Metric("BadRequestCount", 1)
+				return
+			}
+			m.Property("mode", "webhook")
+			d, err = whAuth.DecideWebhook(r.Context(), secretID, v, secretsmanager.WebhookRequest{Header: r.Header, Body: body})
+		} else if q := r.URL.Query(); q.Has("token") {
+			tokAuth, ok := auth.(secretsmanager.TokenAuthorizer)
+			if !ok {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				m.Metric("BadRequestCount", 1)
+				return
+			}
+			m.Property("mode", "token")
+			d, err = tokAuth.DecideToken(r.Context(), secretID, strings.TrimSpace(q.Get("token")))
+		} else if q.Has("signature") {
+			sigAuth, ok := auth.(secretsmanager.SignatureAuthorizer)
+			if !ok {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				m.Metric("BadRequestCount", 1)
+				return
+			}
+			m.Property("mode", "signature")
+			d, err = sigAuth.DecideSignature(r.Context(), secretID, signedRequest(q))
+		} else if keyAuth, ok := auth.(secretsmanager.KeyringAuthorizer); ok {
+			k := strings.TrimSpace(q.Get("key"))
+			d, err = keyAuth.DecideKey(r.Context(), secretID, k, secretsmanager.Scope{Method: q.Get("method"), Path: q.Get("path")})
+		} else {
+			k := strings.TrimSpace(q.Get("key"))
+			d, err = auth.Decide(r.Context(), secretID, k)
 		}
+		logDecision(m, d)
 		if err != nil {
 			if errors.Is(err, secretsmanager.ErrUnauthorized) {
 				http.Error(w, err.Error(), http.StatusUnauthorized)
 				m.Metric("UnauthorizedCount", 1)
 				return
 			}
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			status := failureStatus(err)
+			http.Error(w, err.Error(), status)
 			m.Metric("InternalErrorCount", 1)
+			m.Property("status", strconv.Itoa(status))
 			return
 		}
 
+		if d.KeyName != "" {
+			w.Header().Set(keyNameHeader, d.KeyName)
+		}
 		w.WriteHeader(http.StatusOK)
 	})
 }
 
+// signedRequest returns the signed request held by the given IPC query parameters.
+func signedRequest(q url.Values) secretsmanager.SignedRequest {
+	return secretsmanager.SignedRequest{
+		Method:    q.Get("method"),
+		Path:      q.Get("path"),
+		Query:     q.Get("query"),
+		BodyHash:  q.Get("body_hash"),
+		Timestamp: q.Get("timestamp"),
+		Nonce:     q.Get("nonce"),
+		Signature: q.Get("signature"),
+	}
+}
+
+// logDecision adds the authorization decision details to the metrics logger.
+func logDecision(m *emf.Logger, d secretsmanager.Decision) {
+	if d.RemoteCalls > 0 {
+		m.Metric("SecretRequestCount", d.RemoteCalls)
+	}
+	if d.BlackListed {
+		m.Metric("BlackListedCount", 1)
+	}
+	m.MetricFloatAs("AuthorizationLatency", float64(d.Latency.Microseconds())/1000, emf.Milliseconds)
+	if d.Stage != "" {
+		m.Property("stage", d.Stage)
+	}
+	if d.KeyName != "" {
+		m.Property("key", d.KeyName)
+	}
+	if d.Reason != "" {
+		m.Property("reason", d.Reason)
+	}
+	if d.Stale {
+		m.Metric("StaleDecisionCount", 1)
+	}
+	if d.FailedOpen {
+		m.Metric("FailOpenCount", 1)
+		println("Authorization failed open:", d.SourceErr.Error())
+	}
+	if d.SourceErr != nil {
+		m.Property("sourceError", d.SourceErr.Error())
+	}
+}
+
+// failureStatus returns the HTTP status code of the given authorization failure.
+func failureStatus(err error) int {
+	switch {
+	case errors.Is(err, secretsmanager.ErrInvalidSecretValue), errors.Is(err, secretsmanager.ErrSignatureDisabled):
+		return http.StatusBadRequest
+	case errors.Is(err, secretsmanager.ErrSourceThrottled):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, secretsmanager.ErrSourceAccessDenied):
+		return http.StatusBadGateway
+	case errors.Is(err, secretsmanager.ErrSecretNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // server is a simple wrapper on top of http.Server.
 // It simplifies the start and the graceful shutdown of the http.server
 type server struct {
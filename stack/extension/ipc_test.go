@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -18,18 +20,33 @@ var _ = (func() interface{} {
 	return nil
 }())
 
-func TestServer(t *testing.T) {
-	randomPort := func() string {
-		l, err := net.Listen("tcp", "127.0.0.1:0")
-		if err != nil {
-			panic("unable to listen on a local address: " + err.Error())
-		}
-		addr := l.Addr().(*net.TCPAddr)
-		l.Close()
+func randomPort() string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("unable to listen on a local address: " + err.Error())
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close()
+
+	return strconv.Itoa(addr.Port)
+}
 
-		return strconv.Itoa(addr.Port)
+// waitForServer blocks until the local server accepts connections on the given port.
+func waitForServer(t *testing.T, port string) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
+	t.Fatal("server is unreachable on port", port)
+}
 
+func TestServer(t *testing.T) {
 	ctx := context.Background()
 
 	t.Run("is reachable", func(t *testing.T) {
@@ -56,6 +73,8 @@ func TestServer(t *testing.T) {
 				_ = s.shutdown()
 			}()
 
+			waitForServer(t, port)
+
 			_, err := http.Get("http://localhost:" + port)
 			if err != nil {
 				t.Fatal("expect err be nil, got", err)
@@ -105,10 +124,11 @@ func TestServer(t *testing.T) {
 	t.Run("with authorize handler", func(t *testing.T) {
 		port := randomPort()
 		token := "random"
-		secret := "random"
+		secret, otherSecret := "random", "other_random"
 		authMock := &secretsmanager.MockAuthorizer{}
 
-		h := MakeHandler(secret, token, authMock)
+		router, _ := NewSecretRouter([]string{secret, otherSecret}, "")
+		h := MakeHandler(router, token, authMock)
 
 		s := NewServer(port, h)
 
@@ -129,6 +149,8 @@ func TestServer(t *testing.T) {
 				_ = s.shutdown()
 			}()
 
+			waitForServer(t, port)
+
 			client := &http.Client{}
 
 			// test invalid request
@@ -140,8 +162,8 @@ func TestServer(t *testing.T) {
 			}
 
 			// test unauthorized request
-			authMock.AuthorizeFn = func(ctx context.Context, secretID, value string) (error, bool) {
-				return secretsmanager.ErrUnauthorized, false
+			authMock.DecideFn = func(ctx context.Context, secretID, value string) (secretsmanager.Decision, error) {
+				return secretsmanager.Decision{Reason: "no matching secret version"}, secretsmanager.ErrUnauthorized
 			}
 			req, _ = http.NewRequest("GET", "http://localhost:"+port+"/?key=xyz", nil)
 			req.Header.Add("X-Aws-Token", token)
@@ -152,8 +174,8 @@ func TestServer(t *testing.T) {
 			}
 
 			// test unexpected authorizer failed request
-			authMock.AuthorizeFn = func(ctx context.Context, secretID, value string) (error, bool) {
-				return secretsmanager.ErrAuthorizationFailed, false
+			authMock.DecideFn = func(ctx context.Context, secretID, value string) (secretsmanager.Decision, error) {
+				return secretsmanager.Decision{RemoteCalls: 1}, secretsmanager.ErrAuthorizationFailed
 			}
 			req, _ = http.NewRequest("GET", "http://localhost:"+port+"/?key=xyz", nil)
 			req.Header.Add("X-Aws-Token", token)
@@ -163,6 +185,133 @@ func TestServer(t *testing.T) {
 				t.Fatalf("expect %d, %d be equals", want, got)
 			}
 
+			// test throttled secret source request
+			authMock.DecideFn = func(ctx context.Context, secretID, value string) (secretsmanager.Decision, error) {
+				return secretsmanager.Decision{RemoteCalls: 1}, fmt.Errorf("%w: rate exceeded", secretsmanager.ErrSourceThrottled)
+			}
+			req, _ = http.NewRequest("GET", "http://localhost:"+port+"/?key=xyz", nil)
+			req.Header.Add("X-Aws-Token", token)
+			r, _ = client.Do(req)
+			r.Body.Close()
+			if want, got := 503, r.StatusCode; want != got {
+				t.Fatalf("expect %d, %d be equals", want, got)
+			}
+
+			// test request routed to another secret
+			authMock.DecideFn = func(ctx context.Context, secretID, value string) (secretsmanager.Decision, error) {
+				if secretID != otherSecret {
+					return secretsmanager.Decision{}, secretsmanager.ErrUnauthorized
+				}
+				return secretsmanager.Decision{Allowed: true}, nil
+			}
+			req, _ = http.NewRequest("GET", "http://localhost:"+port+"/?key=xyz&secret="+otherSecret, nil)
+			req.Header.Add("X-Aws-Token", token)
+			r, _ = client.Do(req)
+			r.Body.Close()
+			if want, got := 200, r.StatusCode; want != got {
+				t.Fatalf("expect %d, %d be equals", want, got)
+			}
+
+			// test request routed to an unknown secret
+			req, _ = http.NewRequest("GET", "http://localhost:"+port+"/?key=xyz&secret=unknown", nil)
+			req.Header.Add("X-Aws-Token", token)
+			r, _ = client.Do(req)
+			r.Body.Close()
+			if want, got := 400, r.StatusCode; want != got {
+				t.Fatalf("expect %d, %d be equals", want, got)
+			}
+
+			// test keyring key request
+			authMock.DecideKeyFn = func(ctx context.Context, secretID, value string, scope secretsmanager.Scope) (secretsmanager.Decision, error) {
+				if scope.Method != "GET" || scope.Path != "/api" {
+					return secretsmanager.Decision{}, secretsmanager.ErrUnauthorized
+				}
+				return secretsmanager.Decision{Allowed: true, KeyName: "partner"}, nil
+			}
+			req, _ = http.NewRequest("GET", "http://localhost:"+port+"/?key=xyz&method=GET&path=%2Fapi", nil)
+			req.Header.Add("X-Aws-Token", token)
+			r, _ = client.Do(req)
+			r.Body.Close()
+			if want, got := 200, r.StatusCode; want != got {
+				t.Fatalf("expect %d, %d be equals", want, got)
+			}
+			if want, got := "partner", r.Header.Get(keyNameHeader); want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			authMock.DecideKeyFn = nil
+
+			// test signed request
+			signed := secretsmanager.SignedRequest{}
+			authMock.DecideSignatureFn = func(ctx context.Context, secretID string, r secretsmanager.SignedRequest) (secretsmanager.Decision, error) {
+				signed = r
+				return secretsmanager.Decision{Allowed: true}, nil
+			}
+			req, _ = http.NewRequest("GET", "http://localhost:"+port+"/?method=POST&path=%2Fhook&query=a%3D1&timestamp=1690000000&nonce=n1&signature=abcd", nil)
+			req.Header.Add("X-Aws-Token", token)
+			r, _ = client.Do(req)
+			r.Body.Close()
+			if want, got := 200, r.StatusCode; want != got {
+				t.Fatalf("expect %d, %d be equals", want, got)
+			}
+			if want, got := (secretsmanager.SignedRequest{
+				Method: "POST", Path: "/hook", Query: "a=1", Timestamp: "1690000000", Nonce: "n1", Signature: "abcd",
+			}), signed; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+
+			// test token request
+			authMock.DecideTokenFn = func(ctx context.Context, secretID, token string) (secretsmanager.Decision, error) {
+				if token != "a.b.c" {
+					return secretsmanager.Decision{}, secretsmanager.ErrUnauthorized
+				}
+				return secretsmanager.Decision{Allowed: true}, nil
+			}
+			req, _ = http.NewRequest("GET", "http://localhost:"+port+"/?token=a.b.c", nil)
+			req.Header.Add("X-Aws-Token", token)
+			r, _ = client.Do(req)
+			r.Body.Close()
+			if want, got := 200, r.StatusCode; want != got {
+				t.Fatalf("expect %d, %d be equals", want, got)
+			}
+
+			// test webhook request
+			_ = router.setWebhooks(otherSecret+"=slack", time.Minute)
+			authMock.DecideWebhookFn = func(ctx context.Context, secretID string, v secretsmanager.WebhookVerifier, r secretsmanager.WebhookRequest) (secretsmanager.Decision, error) {
+				if secretID != otherSecret || v.Name() != secretsmanager.WebhookSlack || string(r.Body) != "payload" || r.Header.Get("X-Slack-Signature") != "v0=abcd" {
+					return secretsmanager.Decision{}, secretsmanager.ErrUnauthorized
+				}
+				return secretsmanager.Decision{Allowed: true}, nil
+			}
+			req, _ = http.NewRequest("POST", "http://localhost:"+port+"/?secret="+otherSecret, strings.NewReader("payload"))
+			req.Header.Add("X-Aws-Token", token)
+			req.Header.Add("X-Slack-Signature", "v0=abcd")
+			r, _ = client.Do(req)
+			r.Body.Close()
+			if want, got := 200, r.StatusCode; want != got {
+				t.Fatalf("expect %d, %d be equals", want, got)
+			}
+
+			// test webhook request to a secret without webhook provider
+			req, _ = http.NewRequest("POST", "http://localhost:"+port+"/", strings.NewReader("payload"))
+			req.Header.Add("X-Aws-Token", token)
+			r, _ = client.Do(req)
+			r.Body.Close()
+			if want, got := 400, r.StatusCode; want != got {
+				t.Fatalf("expect %d, %d be equals", want, got)
+			}
+
+			// test signed request with signatures disabled
+			authMock.DecideSignatureFn = func(ctx context.Context, secretID string, r secretsmanager.SignedRequest) (secretsmanager.Decision, error) {
+				return secretsmanager.Decision{}, secretsmanager.ErrSignatureDisabled
+			}
+			req, _ = http.NewRequest("GET", "http://localhost:"+port+"/?timestamp=1690000000&nonce=n1&signature=abcd", nil)
+			req.Header.Add("X-Aws-Token", token)
+			r, _ = client.Do(req)
+			r.Body.Close()
+			if want, got := 400, r.StatusCode; want != got {
+				t.Fatalf("expect %d, %d be equals", want, got)
+			}
+
 			return nil
 		})
 
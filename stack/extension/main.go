@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/ln80/secure-lambda-url/secretsmanager"
+	"github.com/ln80/secure-lambda-url/ssm"
 )
 
 var (
@@ -21,37 +24,35 @@ var (
 var (
 	extensionClient *client
 	ipc             *server
+	proxy           *server
 	cache           *secretsmanager.Janitor
+	refresher       *secretsmanager.Refresher
 )
 
 const (
-	defaultPort = "3579"
+	defaultPort      = "3579"
+	defaultProxyPort = "9009"
 )
 
 func init() {
 	if _unitTesting {
 		return
 	}
-	secretEndpoint := os.Getenv("SECURE_LAMBDA_URL_SECRET_ENDPOINT")
-	if secretEndpoint == "" {
+	secretIDs := os.Getenv("SECURE_LAMBDA_URL_SECRET_ARN")
+	if secretIDs == "" {
 		println("Init failed", fmt.Errorf(`
 			missed env params:
-			SECURE_LAMBDA_URL_SECRET_ENDPOINT: %s,
-			`, secretEndpoint))
+			SECURE_LAMBDA_URL_SECRET_ARN: %s,
+			`, secretIDs))
 		os.Exit(1)
 	}
-	secretID := os.Getenv("SECURE_LAMBDA_URL_SECRET_ARN")
-	if secretID == "" {
-		println("Init failed", fmt.Errorf(`
-			missed env params:
-			SECURE_LAMBDA_URL_SECRET_ARN: %s,
-			`, secretID))
+	router, err := NewSecretRouter(strings.Split(secretIDs, ","), os.Getenv("SECURE_LAMBDA_URL_ROUTES"))
+	if err != nil {
+		println("Init failed", err)
 		os.Exit(1)
 	}
 
-	cfg, err := config.LoadDefaultConfig(
-		context.Background(),
-	)
+	source, err := newSecretSource(os.Getenv("SECURE_LAMBDA_URL_SOURCE"))
 	if err != nil {
 		println("Init failed", err)
 		os.Exit(1)
@@ -64,22 +65,245 @@ func init() {
 
 	cache = secretsmanager.NewJanitor(20 * time.Minute)
 
+	blCapacity, err := envInt("SECURE_LAMBDA_URL_BLACKLIST_CAPACITY", -1)
+	if err != nil {
+		println("Init failed", err)
+		os.Exit(1)
+	}
+	blTTL, err := envDuration("SECURE_LAMBDA_URL_BLACKLIST_TTL", -1)
+	if err != nil {
+		println("Init failed", err)
+		os.Exit(1)
+	}
+
+	availability := secretsmanager.FailClosed
+	switch v := os.Getenv("SECURE_LAMBDA_URL_AVAILABILITY"); v {
+	case "", secretsmanager.FailClosed.String():
+	case secretsmanager.ServeStale.String():
+		availability = secretsmanager.ServeStale
+	case secretsmanager.FailOpen.String():
+		availability = secretsmanager.FailOpen
+	default:
+		println("Init failed", fmt.Errorf("invalid SECURE_LAMBDA_URL_AVAILABILITY: %s", v))
+		os.Exit(1)
+	}
+	maxStaleness, err := envDuration("SECURE_LAMBDA_URL_MAX_STALENESS", -1)
+	if err != nil {
+		println("Init failed", err)
+		os.Exit(1)
+	}
+
+	deploymentGracePeriod, err := envDuration("SECURE_LAMBDA_URL_DEPLOYMENT_GRACE_PERIOD", -1)
+	if err != nil {
+		println("Init failed", err)
+		os.Exit(1)
+	}
+
+	// signed requests are verified using the plain secret values, they are only kept in cache if enabled
+	signatureEnabled := os.Getenv("SECURE_LAMBDA_URL_SIGNATURE_ENABLED") == "true"
+	clockSkew, err := envDuration("SECURE_LAMBDA_URL_CLOCK_SKEW", 5*time.Minute)
+	// a zero clock skew would disable the replay protection
+	if err == nil && clockSkew <= 0 {
+		err = fmt.Errorf("invalid SECURE_LAMBDA_URL_CLOCK_SKEW: %v is not positive", clockSkew)
+	}
+	if err != nil {
+		println("Init failed", err)
+		os.Exit(1)
+	}
+
+	// tokens are signed using the plain secret values as well
+	tokenEnabled := os.Getenv("SECURE_LAMBDA_URL_TOKEN_ENABLED") == "true"
+
+	// webhook providers sign requests using the plain secret values as well
+	webhookTolerance, err := envDuration("SECURE_LAMBDA_URL_WEBHOOK_TOLERANCE", 5*time.Minute)
+	if err != nil {
+		println("Init failed", err)
+		os.Exit(1)
+	}
+	webhooks := os.Getenv("SECURE_LAMBDA_URL_WEBHOOKS")
+	if err := router.setWebhooks(webhooks, webhookTolerance); err != nil {
+		println("Init failed", fmt.Errorf("invalid SECURE_LAMBDA_URL_WEBHOOKS: %w", err))
+		os.Exit(1)
+	}
+
+	auth := secretsmanager.NewSourceAuthorizer(source, cache,
+		func(ac *secretsmanager.AuthorizerConfig) {
+			if blCapacity >= 0 {
+				ac.BlackListCapacity = blCapacity
+			}
+			if blTTL >= 0 {
+				ac.BlackListTTL = blTTL
+			}
+			ac.Availability = availability
+			if deploymentGracePeriod >= 0 {
+				ac.DeploymentGracePeriod = deploymentGracePeriod
+			}
+			if maxStaleness >= 0 {
+				ac.MaxStaleness = maxStaleness
+			}
+			ac.SignatureEnabled = signatureEnabled || tokenEnabled || webhooks != ""
+			ac.TokenIssuer = os.Getenv("SECURE_LAMBDA_URL_TOKEN_ISSUER")
+			ac.TokenAudience = os.Getenv("SECURE_LAMBDA_URL_TOKEN_AUDIENCE")
+			ac.ClockSkew = clockSkew
+			ac.Keyring = os.Getenv("SECURE_LAMBDA_URL_KEYRING_ENABLED") == "true"
+			ac.SecretField = os.Getenv("SECURE_LAMBDA_URL_SECRET_FIELD")
+			ac.KeyFormat = os.Getenv("SECURE_LAMBDA_URL_KEY_FORMAT_ENABLED") == "true"
+			ac.LegacyKeys = os.Getenv("SECURE_LAMBDA_URL_LEGACY_KEYS") == "true"
+			ac.VersionAddressed = os.Getenv("SECURE_LAMBDA_URL_VERSION_ADDRESSED") == "true"
+		},
+	)
+
+	// the refresher keeps the cache up to date, otherwise the cache is periodically cleared by the janitor
+	refreshInterval, err := envDuration("SECURE_LAMBDA_URL_REFRESH_INTERVAL", -1)
+	if err != nil {
+		println("Init failed", err)
+		os.Exit(1)
+	}
+	pollInterval, err := envDuration("SECURE_LAMBDA_URL_POLL_INTERVAL", -1)
+	if err != nil {
+		println("Init failed", err)
+		os.Exit(1)
+	}
+	if refreshInterval != 0 {
+		refresher = secretsmanager.NewRefresher(auth, router.secrets,
+			func(rc *secretsmanager.RefresherConfig) {
+				if refreshInterval > 0 {
+					rc.RefreshInterval = refreshInterval
+					if rc.Jitter > refreshInterval/10 {
+						rc.Jitter = refreshInterval / 10
+					}
+				}
+				if pollInterval >= 0 {
+					rc.PollInterval = pollInterval
+				}
+			},
+		)
+
+		// prefetch failures are not fatal, secrets are lazily fetched and refreshed in background
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := refresher.Prefetch(ctx); err != nil {
+			println("Prefetch failed", err)
+		}
+		cancel()
+	}
+
 	ipc = NewServer(
 		port,
-		MakeHandler(secretID, os.Getenv("AWS_SESSION_TOKEN"),
-			secretsmanager.NewAuthorizer(secretsmanager.NewClient(cfg, secretEndpoint), cache),
-		),
+		MakeHandler(router, os.Getenv("AWS_SESSION_TOKEN"), auth),
 	)
 
+	// Runtime API proxy mode requires the function to use the 'secure-lambda-url-proxy' wrapper script
+	if os.Getenv("SECURE_LAMBDA_URL_PROXY_ENABLED") == "true" {
+		headerName := os.Getenv("SECURE_LAMBDA_URL_HEADER_NAME")
+		if headerName == "" && !signatureEnabled && webhooks == "" {
+			println("Init failed", fmt.Errorf(`
+			missed env params:
+			SECURE_LAMBDA_URL_HEADER_NAME: %s,
+			`, headerName))
+			os.Exit(1)
+		}
+		proxyPort := os.Getenv("SECURE_LAMBDA_URL_PROXY_PORT")
+		if proxyPort == "" {
+			proxyPort = defaultProxyPort
+		}
+
+		proxy = NewServer(
+			proxyPort,
+			MakeProxyHandler(os.Getenv("AWS_LAMBDA_RUNTIME_API"), router, headerName, auth,
+				func(pc *ProxyConfig) {
+					pc.SignatureEnabled = signatureEnabled
+					pc.TokenEnabled = tokenEnabled
+				},
+			),
+		)
+	}
+
 	extensionClient = NewClient(os.Getenv("AWS_LAMBDA_RUNTIME_API"))
 }
 
+// newSecretSource returns the secret source of the given kind. Secrets Manager is used by default.
+func newSecretSource(kind string) (secretsmanager.SecretSource, error) {
+	switch kind {
+	case "file":
+		return secretsmanager.FileSource{}, nil
+	case "env":
+		return secretsmanager.NewEnvSource(), nil
+	case "", "secretsmanager", "ssm":
+	default:
+		return nil, fmt.Errorf("invalid SECURE_LAMBDA_URL_SOURCE: %s", kind)
+	}
+
+	cfg, err := config.LoadDefaultConfig(
+		context.Background(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind == "ssm" {
+		var labels []string
+		if v := os.Getenv("SECURE_LAMBDA_URL_SSM_LABELS"); v != "" {
+			if labels = strings.Split(v, ","); len(labels) != 3 {
+				return nil, fmt.Errorf("invalid SECURE_LAMBDA_URL_SSM_LABELS: %s", v)
+			}
+		}
+		return ssm.NewSource(ssm.NewClient(cfg, os.Getenv("SECURE_LAMBDA_URL_SSM_ENDPOINT")), func(sc *ssm.SourceConfig) {
+			if labels != nil {
+				sc.CurrentLabel = strings.TrimSpace(labels[0])
+				sc.PreviousLabel = strings.TrimSpace(labels[1])
+				sc.PendingLabel = strings.TrimSpace(labels[2])
+			}
+		}), nil
+	}
+
+	secretEndpoint := os.Getenv("SECURE_LAMBDA_URL_SECRET_ENDPOINT")
+	if secretEndpoint == "" {
+		return nil, fmt.Errorf(`
+			missed env params:
+			SECURE_LAMBDA_URL_SECRET_ENDPOINT: %s,
+			`, secretEndpoint)
+	}
+	return secretsmanager.NewSecretsManagerSource(secretsmanager.NewClient(cfg, secretEndpoint)), nil
+}
+
+// envDuration returns the duration held by the given env var, or def if it isn't set.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
+
+// envInt returns the integer held by the given env var, or def if it isn't set.
+func envInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return i, nil
+}
+
 func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 
-	cache.Run(ctx, func() {
-		println("Secret cache cleared")
-	})
+	if refresher != nil {
+		refresher.Run(ctx, func(secretID string, err error) {
+			println("Secret refresh failed", secretID, err)
+		})
+	} else {
+		cache.Run(ctx, func() {
+			println("Secret cache cleared")
+		})
+	}
 
 	// Extension has to terminate if either client or IPC server has terminated
 
@@ -96,7 +320,14 @@ func main() {
 		return ipc.Start(ctx)
 	})
 
-	// A Shameless hack to give IPC server a chance to start before registering the extension.
+	if proxy != nil {
+		g.Go(func() error {
+			defer cancel()
+			return proxy.Start(ctx)
+		})
+	}
+
+	// A Shameless hack to give IPC (and proxy) server a chance to start before registering the extension.
 	// Otherwise, lambda runtime might prematurely receive events and call the IPC server.
 	// TBD: sleep duration
 	time.Sleep(10 * time.Millisecond)
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnv(t *testing.T) {
+	name := "SECURE_LAMBDA_URL_TEST_VALUE"
+
+	t.Run("with unset value", func(t *testing.T) {
+		d, err := envDuration(name, time.Minute)
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := time.Minute, d; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		i, err := envInt(name, -1)
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := -1, i; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with valid value", func(t *testing.T) {
+		t.Setenv(name, "10")
+		i, err := envInt(name, -1)
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := 10, i; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+
+		t.Setenv(name, "10s")
+		d, err := envDuration(name, time.Minute)
+		if err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := 10*time.Second, d; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("with invalid value", func(t *testing.T) {
+		t.Setenv(name, "ten")
+		if _, err := envDuration(name, time.Minute); err == nil || !strings.Contains(err.Error(), name) {
+			t.Fatalf("expect err name %s, got %v", name, err)
+		}
+		if _, err := envInt(name, -1); err == nil || !strings.Contains(err.Error(), name) {
+			t.Fatalf("expect err name %s, got %v", name, err)
+		}
+	})
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ln80/secure-lambda-url/secretsmanager"
+	"github.com/prozz/aws-embedded-metrics-golang/emf"
+)
+
+const (
+	runtimeAPIVersion = "2018-06-01"
+
+	nextInvocationPath = "/" + runtimeAPIVersion + "/runtime/invocation/next"
+
+	runtimeRequestIDHeader = "Lambda-Runtime-Aws-Request-Id"
+
+	signatureHeader          = "X-Signature"
+	signatureTimestampHeader = "X-Signature-Timestamp"
+	signatureNonceHeader     = "X-Signature-Nonce"
+)
+
+type ProxyConfig struct {
+	// SignatureEnabled requires Function URL requests to be signed (see secretsmanager.SignedRequest)
+	// using the 'X-Signature', 'X-Signature-Timestamp' and 'X-Signature-Nonce' headers,
+	// instead of carrying the secret value header. It requires the authorizer to be a secretsmanager.SignatureAuthorizer.
+	SignatureEnabled bool
+
+	// TokenEnabled requires the secret value header to carry a compact JWT signed using the secret value
+	// (optionally prefixed by 'Bearer '). It requires the authorizer to be a secretsmanager.TokenAuthorizer.
+	TokenEnabled bool
+}
+
+// runtimeProxy sits between the function runtime and the Lambda Runtime API.
+// It intercepts the next invocation events, and answers unauthorized Function URL requests
+// on behalf of the function.
+type runtimeProxy struct {
+	baseURL    string
+	httpClient *http.Client
+
+	router     *secretRouter
+	headerName string
+	auth       secretsmanager.DecisionAuthorizer
+	cfg        *ProxyConfig
+}
+
+// MakeProxyHandler returns the http.Handler used by the extension in Runtime API proxy mode.
+// The function runtime is pointed to this handler using the 'secure-lambda-url-proxy' wrapper script.
+// Every call is forwarded to the actual Runtime API, except for Function URL invocations which fail
+// authorization: they are answered directly and never reach the function handler.
+// The secret used to authorize a request is resolved by the router based on the request path.
+func MakeProxyHandler(runtimeAPI string, router *secretRouter, headerName string, auth secretsmanager.DecisionAuthorizer, opts ...func(*ProxyConfig)) http.Handler {
+	cfg := &ProxyConfig{}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		opt(cfg)
+	}
+
+	p := &runtimeProxy{
+		baseURL:    fmt.Sprintf("http://%s", runtimeAPI),
+		httpClient: &http.Client{},
+		router:     router,
+		headerName: headerName,
+		auth:       auth,
+		cfg:        cfg,
+	}
+
+	rp := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: runtimeAPI})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == nextInvocationPath {
+			p.next(w, r)
+			return
+		}
+		rp.ServeHTTP(w, r)
+	})
+}
+
+// next long polls the Runtime API for the next event and only hands authorized events to the runtime.
+func (p *runtimeProxy) next(w http.ResponseWriter, r *http.Request) {
+	for {
+		res, body, err := p.fetchNext(r.Context())
+		if err != nil {
+			println("Proxy next invocation failed:", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		if res.StatusCode == http.StatusOK {
+			status := p.authorize(r.Context(), body)
+			if status != http.StatusOK {
+				// The function handler must never receive the event, if the Runtime API
+				// refuses our response, let the runtime fail instead.
+				if err := p.respond(r.Context(), res.Header.Get(runtimeRequestIDHeader), status); err != nil {
+					println("Proxy response failed:", err)
+					http.Error(w, err.Error(), http.StatusBadGateway)
+					return
+				}
+				continue
+			}
+		}
+
+		for k, vv := range res.Header {
+			for _, v := range vv {
+				w.Header().Add(k, v)
+			}
+		}
+		w.WriteHeader(res.StatusCode)
+		_, _ = w.Write(body)
+		return
+	}
+}
+
+func (p *runtimeProxy) fetchNext(ctx context.Context) (*http.Response, []byte, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+nextInvocationPath, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpRes, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpRes.Body.Close()
+	body, err := io.ReadAll(httpRes.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return httpRes, body, nil
+}
+
+// authorize returns the HTTP status code of the authorization result.
+// Events other than Function URL requests (i.e. direct invocations or event sources)
+// are already authorized by IAM and are considered as authorized.
+func (p *runtimeProxy) authorize(ctx context.Context, event []byte) int {
+	evt := events.LambdaFunctionURLRequest{}
+	if err := json.Unmarshal(event, &evt); err != nil || evt.RequestContext.HTTP.Method == "" {
+		return http.StatusOK
+	}
+
+	m := emf.New().
+		Namespace("Ln80/SecureLambdaUrl")
+	defer m.Log()
+
+	header := func(name string) string {
+		for k, v := range evt.Headers {
+			if strings.EqualFold(k, name) {
+				return strings.TrimSpace(v)
+			}
+		}
+		return ""
+	}
+
+	secretID := p.router.route(evt.RawPath)
+
+	var (
+		d   secretsmanager.Decision
+		err error
+	)
+	if v, ok := p.router.webhook(secretID); ok {
+		m.Property("mode", "webhook")
+		d, err = p.decideWebhook(ctx, secretID, v, evt)
+	} else if p.cfg.SignatureEnabled {
+		m.Property("mode", "signature")
+		d, err = p.decideSignature(ctx, secretID, evt, header)
+	} else if p.cfg.TokenEnabled {
+		m.Property("mode", "token")
+		d, err = p.decideToken(ctx, secretID, header(p.headerName))
+	} else {
+		d, err = p.decideKey(ctx, secretID, evt, header(p.headerName))
+	}
+	logDecision(m, d)
+	if err != nil {
+		if errors.Is(err, secretsmanager.ErrUnauthorized) || errors.Is(err, secretsmanager.ErrInvalidSecretValue) {
+			m.Metric("UnauthorizedCount", 1)
+			return http.StatusUnauthorized
+		}
+		status := failureStatus(err)
+		m.Metric("InternalErrorCount", 1)
+		m.Property("status", strconv.Itoa(status))
+		return status
+	}
+
+	return http.StatusOK
+}
+
+// decideSignature authorizes the given signed Function URL request.
+func (p *runtimeProxy) decideSignature(ctx context.Context, secretID string, evt events.LambdaFunctionURLRequest, header func(string) string) (secretsmanager.Decision, error) {
+	sigAuth, ok := p.auth.(secretsmanager.SignatureAuthorizer)
+	if !ok {
+		return secretsmanager.Decision{}, secretsmanager.ErrSignatureDisabled
+	}
+
+	body, err := eventBody(evt)
+	if err != nil {
+		return secretsmanager.Decision{Reason: "invalid body encoding"}, secretsmanager.ErrInvalidSecretValue
+	}
+
+	return sigAuth.DecideSignature(ctx, secretID, secretsmanager.SignedRequest{
+		Method:    evt.RequestContext.HTTP.Method,
+		Path:      evt.RawPath,
+		Query:     evt.RawQueryString,
+		BodyHash:  secretsmanager.HashBody(body),
+		Timestamp: header(signatureTimestampHeader),
+		Nonce:     header(signatureNonceHeader),
+		Signature: header(signatureHeader),
+	})
+}
+
+// decideKey authorizes the given Function URL request key. For keyring secrets,
+// the request method and path are the scope of the key.
+func (p *runtimeProxy) decideKey(ctx context.Context, secretID string, evt events.LambdaFunctionURLRequest, key string) (secretsmanager.Decision, error) {
+	keyAuth, ok := p.auth.(secretsmanager.KeyringAuthorizer)
+	if !ok {
+		return p.auth.Decide(ctx, secretID, key)
+	}
+	return keyAuth.DecideKey(ctx, secretID, key, secretsmanager.Scope{
+		Method: evt.RequestContext.HTTP.Method,
+		Path:   evt.RawPath,
+	})
+}
+
+// decideToken authorizes the given Function URL request token.
+func (p *runtimeProxy) decideToken(ctx context.Context, secretID, token string) (secretsmanager.Decision, error) {
+	tokAuth, ok := p.auth.(secretsmanager.TokenAuthorizer)
+	if !ok {
+		return secretsmanager.Decision{}, secretsmanager.ErrSignatureDisabled
+	}
+
+	if len(token) > len("Bearer ") && strings.EqualFold(token[:len("Bearer ")], "Bearer ") {
+		token = strings.TrimSpace(token[len("Bearer "):])
+	}
+	return tokAuth.DecideToken(ctx, secretID, token)
+}
+
+// decideWebhook authorizes the given Function URL request signed by a webhook provider.
+func (p *runtimeProxy) decideWebhook(ctx context.Context, secretID string, v secretsmanager.WebhookVerifier, evt events.LambdaFunctionURLRequest) (secretsmanager.Decision, error) {
+	whAuth, ok := p.auth.(secretsmanager.WebhookAuthorizer)
+	if !ok {
+		return secretsmanager.Decision{}, secretsmanager.ErrSignatureDisabled
+	}
+
+	body, err := eventBody(evt)
+	if err != nil {
+		return secretsmanager.Decision{Reason: "invalid body encoding"}, secretsmanager.ErrInvalidSecretValue
+	}
+	h := http.Header{}
+	for k, v := range evt.Headers {
+		h.Set(k, v)
+	}
+
+	return whAuth.DecideWebhook(ctx, secretID, v, secretsmanager.WebhookRequest{Header: h, Body: body})
+}
+
+// eventBody returns the raw body of the given Function URL request.
+func eventBody(evt events.LambdaFunctionURLRequest) ([]byte, error) {
+	if evt.IsBase64Encoded {
+		return base64.StdEncoding.DecodeString(evt.Body)
+	}
+	return []byte(evt.Body), nil
+}
+
+// respond sends a Function URL response with the given status to the Runtime API
+// on behalf of the function handler.
+func (p *runtimeProxy) respond(ctx context.Context, requestID string, status int) error {
+	if requestID == "" {
+		return errors.New("missing invocation request ID")
+	}
+
+	msg, _ := json.Marshal(map[string]string{"message": http.StatusText(status)})
+	reqBody, err := json.Marshal(events.LambdaFunctionURLResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(msg),
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/runtime/invocation/%s/response", p.baseURL, runtimeAPIVersion, requestID)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	httpRes, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close()
+	_, _ = io.Copy(io.Discard, httpRes.Body)
+	if httpRes.StatusCode != http.StatusAccepted && httpRes.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed with status %s", httpRes.Status)
+	}
+	return nil
+}
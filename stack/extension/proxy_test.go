@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ln80/secure-lambda-url/secretsmanager"
+)
+
+// fakeRuntimeAPI is a minimal implementation of the Lambda Runtime API.
+// It serves the given events in order and records the invocation responses.
+type fakeRuntimeAPI struct {
+	mu        sync.Mutex
+	events    []string
+	served    int
+	responses map[string]string
+}
+
+func newFakeRuntimeAPI(events ...string) *fakeRuntimeAPI {
+	return &fakeRuntimeAPI{
+		events:    events,
+		responses: make(map[string]string),
+	}
+}
+
+func (f *fakeRuntimeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == nextInvocationPath {
+		if len(f.events) == 0 {
+			http.Error(w, "no more events", http.StatusInternalServerError)
+			return
+		}
+		f.served++
+		id := "req-" + strconv.Itoa(f.served)
+		w.Header().Set(runtimeRequestIDHeader, id)
+		_, _ = w.Write([]byte(f.events[0]))
+		f.events = f.events[1:]
+		return
+	}
+
+	prefix := "/" + runtimeAPIVersion + "/runtime/invocation/"
+	if strings.HasPrefix(r.URL.Path, prefix) && strings.HasSuffix(r.URL.Path, "/response") {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), "/response")
+		body, _ := io.ReadAll(r.Body)
+		f.responses[id] = string(body)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	http.Error(w, "not found", http.StatusNotFound)
+}
+
+func (f *fakeRuntimeAPI) response(id string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	resp, ok := f.responses[id]
+	return resp, ok
+}
+
+func TestProxy(t *testing.T) {
+	secret, headerName := "random", "X-Secure-Key"
+	router, _ := NewSecretRouter([]string{secret}, "")
+
+	urlEvent := func(key string) string {
+		evt := events.LambdaFunctionURLRequest{
+			RawPath: "/",
+			Headers: map[string]string{strings.ToLower(headerName): key},
+			RequestContext: events.LambdaFunctionURLRequestContext{
+				HTTP: events.LambdaFunctionURLRequestContextHTTPDescription{Method: http.MethodGet},
+			},
+		}
+		b, _ := json.Marshal(evt)
+		return string(b)
+	}
+
+	authMock := &secretsmanager.MockAuthorizer{
+		DecideFn: func(ctx context.Context, secretID, value string) (secretsmanager.Decision, error) {
+			if value != "valid" {
+				return secretsmanager.Decision{}, secretsmanager.ErrUnauthorized
+			}
+			return secretsmanager.Decision{Allowed: true}, nil
+		},
+	}
+
+	t.Run("answer unauthorized events", func(t *testing.T) {
+		runtime := newFakeRuntimeAPI(urlEvent("invalid"), urlEvent("valid"))
+		upstream := httptest.NewServer(runtime)
+		defer upstream.Close()
+
+		p := httptest.NewServer(MakeProxyHandler(strings.TrimPrefix(upstream.URL, "http://"), router, headerName, authMock))
+		defer p.Close()
+
+		r, err := http.Get(p.URL + nextInvocationPath)
+		if err != nil {
+			t.Fatal("expect err be nil, got", err)
+		}
+		body, _ := io.ReadAll(r.Body)
+		r.Body.Close()
+
+		if want, got := urlEvent("valid"), string(body); want != got {
+			t.Fatalf("expect %s, %s be equals", want, got)
+		}
+		if want, got := "req-2", r.Header.Get(runtimeRequestIDHeader); want != got {
+			t.Fatalf("expect %s, %s be equals", want, got)
+		}
+
+		resp, ok := runtime.response("req-1")
+		if !ok {
+			t.Fatal("expect unauthorized event be answered by the proxy")
+		}
+		res := events.LambdaFunctionURLResponse{}
+		if err := json.Unmarshal([]byte(resp), &res); err != nil {
+			t.Fatal("expect err be nil, got", err)
+		}
+		if want, got := http.StatusUnauthorized, res.StatusCode; want != got {
+			t.Fatalf("expect %d, %d be equals", want, got)
+		}
+	})
+
+	t.Run("forward other events and calls", func(t *testing.T) {
+		runtime := newFakeRuntimeAPI(`{"source": "aws.events"}`)
+		upstream := httptest.NewServer(runtime)
+		defer upstream.Close()
+
+		p := httptest.NewServer(MakeProxyHandler(strings.TrimPrefix(upstream.URL, "http://"), router, headerName, authMock))
+		defer p.Close()
+
+		r, err := http.Get(p.URL + nextInvocationPath)
+		if err != nil {
+			t.Fatal("expect err be nil, got", err)
+		}
+		body, _ := io.ReadAll(r.Body)
+		r.Body.Close()
+		if want, got := `{"source": "aws.events"}`, string(body); want != got {
+			t.Fatalf("expect %s, %s be equals", want, got)
+		}
+
+		r, err = http.Post(p.URL+"/"+runtimeAPIVersion+"/runtime/invocation/req-1/response", "application/json", strings.NewReader(`"ok"`))
+		if err != nil {
+			t.Fatal("expect err be nil, got", err)
+		}
+		r.Body.Close()
+		if want, got := http.StatusAccepted, r.StatusCode; want != got {
+			t.Fatalf("expect %d, %d be equals", want, got)
+		}
+		if resp, _ := runtime.response("req-1"); resp != `"ok"` {
+			t.Fatalf("expect function response be forwarded, got %s", resp)
+		}
+	})
+
+	t.Run("verify signed events", func(t *testing.T) {
+		evt := events.LambdaFunctionURLRequest{
+			RawPath:         "/hook",
+			RawQueryString:  "a=1",
+			Body:            base64.StdEncoding.EncodeToString([]byte(`{"id":1}`)),
+			IsBase64Encoded: true,
+			Headers: map[string]string{
+				"x-signature":           "abcd",
+				"x-signature-timestamp": "1690000000",
+				"x-signature-nonce":     "n1",
+			},
+			RequestContext: events.LambdaFunctionURLRequestContext{
+				HTTP: events.LambdaFunctionURLRequestContextHTTPDescription{Method: http.MethodPost},
+			},
+		}
+		b, _ := json.Marshal(evt)
+
+		runtime := newFakeRuntimeAPI(string(b))
+		upstream := httptest.NewServer(runtime)
+		defer upstream.Close()
+
+		signed := secretsmanager.SignedRequest{}
+		auth := &secretsmanager.MockAuthorizer{
+			DecideFn: func(ctx context.Context, secretID, value string) (secretsmanager.Decision, error) {
+				t.Fatal("expect static header not be checked")
+				return secretsmanager.Decision{}, nil
+			},
+			DecideSignatureFn: func(ctx context.Context, secretID string, r secretsmanager.SignedRequest) (secretsmanager.Decision, error) {
+				signed = r
+				return secretsmanager.Decision{Allowed: true}, nil
+			},
+		}
+
+		p := httptest.NewServer(MakeProxyHandler(strings.TrimPrefix(upstream.URL, "http://"), router, "", auth,
+			func(pc *ProxyConfig) {
+				pc.SignatureEnabled = true
+			},
+		))
+		defer p.Close()
+
+		r, err := http.Get(p.URL + nextInvocationPath)
+		if err != nil {
+			t.Fatal("expect err be nil, got", err)
+		}
+		r.Body.Close()
+
+		if want, got := (secretsmanager.SignedRequest{
+			Method:    http.MethodPost,
+			Path:      "/hook",
+			Query:     "a=1",
+			BodyHash:  secretsmanager.HashBody([]byte(`{"id":1}`)),
+			Timestamp: "1690000000",
+			Nonce:     "n1",
+			Signature: "abcd",
+		}), signed; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+
+	t.Run("verify token events", func(t *testing.T) {
+		runtime := newFakeRuntimeAPI(urlEvent("Bearer a.b.c"))
+		upstream := httptest.NewServer(runtime)
+		defer upstream.Close()
+
+		received := ""
+		auth := &secretsmanager.MockAuthorizer{
+			DecideTokenFn: func(ctx context.Context, secretID, token string) (secretsmanager.Decision, error) {
+				received = token
+				return secretsmanager.Decision{}, secretsmanager.ErrUnauthorized
+			},
+		}
+
+		p := httptest.NewServer(MakeProxyHandler(strings.TrimPrefix(upstream.URL, "http://"), router, headerName, auth,
+			func(pc *ProxyConfig) {
+				pc.TokenEnabled = true
+			},
+		))
+		defer p.Close()
+
+		// the only event is unauthorized, the proxy fails to fetch the next one
+		r, err := http.Get(p.URL + nextInvocationPath)
+		if err != nil {
+			t.Fatal("expect err be nil, got", err)
+		}
+		r.Body.Close()
+
+		if want, got := "a.b.c", received; want != got {
+			t.Fatalf("expect %s, %s be equals", want, got)
+		}
+		if _, ok := runtime.response("req-1"); !ok {
+			t.Fatal("expect unauthorized event be answered by the proxy")
+		}
+	})
+
+	t.Run("verify webhook events", func(t *testing.T) {
+		router, _ := NewSecretRouter([]string{secret, "github"}, "/webhooks/github=github")
+		_ = router.setWebhooks("github=github", time.Minute)
+
+		evt := events.LambdaFunctionURLRequest{
+			RawPath: "/webhooks/github",
+			Body:    `{"action":"opened"}`,
+			Headers: map[string]string{"x-hub-signature-256": "sha256=abcd"},
+			RequestContext: events.LambdaFunctionURLRequestContext{
+				HTTP: events.LambdaFunctionURLRequestContextHTTPDescription{Method: http.MethodPost},
+			},
+		}
+		b, _ := json.Marshal(evt)
+
+		runtime := newFakeRuntimeAPI(string(b))
+		upstream := httptest.NewServer(runtime)
+		defer upstream.Close()
+
+		var (
+			verified string
+			received secretsmanager.WebhookRequest
+		)
+		auth := &secretsmanager.MockAuthorizer{
+			DecideFn: func(ctx context.Context, secretID, value string) (secretsmanager.Decision, error) {
+				t.Fatal("expect static header not be checked")
+				return secretsmanager.Decision{}, nil
+			},
+			DecideWebhookFn: func(ctx context.Context, secretID string, v secretsmanager.WebhookVerifier, r secretsmanager.WebhookRequest) (secretsmanager.Decision, error) {
+				verified, received = secretID+"/"+v.Name(), r
+				return secretsmanager.Decision{Allowed: true}, nil
+			},
+		}
+
+		p := httptest.NewServer(MakeProxyHandler(strings.TrimPrefix(upstream.URL, "http://"), router, headerName, auth))
+		defer p.Close()
+
+		r, err := http.Get(p.URL + nextInvocationPath)
+		if err != nil {
+			t.Fatal("expect err be nil, got", err)
+		}
+		r.Body.Close()
+
+		if want, got := "github/github", verified; want != got {
+			t.Fatalf("expect %s, %s be equals", want, got)
+		}
+		if want, got := "sha256=abcd", received.Header.Get("X-Hub-Signature-256"); want != got {
+			t.Fatalf("expect %s, %s be equals", want, got)
+		}
+		if want, got := `{"action":"opened"}`, string(received.Body); want != got {
+			t.Fatalf("expect %s, %s be equals", want, got)
+		}
+	})
+
+	t.Run("route events to secrets", func(t *testing.T) {
+		router, _ := NewSecretRouter([]string{secret, "github"}, "/webhooks/github=github")
+
+		evt := events.LambdaFunctionURLRequest{
+			RawPath: "/webhooks/github/push",
+			Headers: map[string]string{strings.ToLower(headerName): "valid"},
+			RequestContext: events.LambdaFunctionURLRequestContext{
+				HTTP: events.LambdaFunctionURLRequestContextHTTPDescription{Method: http.MethodPost},
+			},
+		}
+		b, _ := json.Marshal(evt)
+
+		runtime := newFakeRuntimeAPI(string(b))
+		upstream := httptest.NewServer(runtime)
+		defer upstream.Close()
+
+		routed := ""
+		auth := &secretsmanager.MockAuthorizer{
+			DecideFn: func(ctx context.Context, secretID, value string) (secretsmanager.Decision, error) {
+				routed = secretID
+				return secretsmanager.Decision{Allowed: true}, nil
+			},
+		}
+
+		p := httptest.NewServer(MakeProxyHandler(strings.TrimPrefix(upstream.URL, "http://"), router, headerName, auth))
+		defer p.Close()
+
+		r, err := http.Get(p.URL + nextInvocationPath)
+		if err != nil {
+			t.Fatal("expect err be nil, got", err)
+		}
+		r.Body.Close()
+
+		if want, got := "github", routed; want != got {
+			t.Fatalf("expect %s, %s be equals", want, got)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ln80/secure-lambda-url/secretsmanager"
+)
+
+// secretRouter resolves the secret used to authorize a request.
+// The first configured secret is used by default.
+type secretRouter struct {
+	secrets []string
+	routes  []secretRoute
+
+	// webhooks maps secret IDs to the webhook verifiers of their providers
+	webhooks map[string]secretsmanager.WebhookVerifier
+}
+
+type secretRoute struct {
+	prefix   string
+	secretID string
+}
+
+// NewSecretRouter returns a router of the given secrets. Routes are an optional comma separated
+// list of 'path-prefix=secret' pairs (i.e. '/github=arn1,/stripe=arn2') used in Runtime API proxy mode.
+// Routes' secrets must be part of the given secrets.
+func NewSecretRouter(secrets []string, routes string) (*secretRouter, error) {
+	r := &secretRouter{}
+	for _, s := range secrets {
+		if s = strings.TrimSpace(s); s != "" {
+			r.secrets = append(r.secrets, s)
+		}
+	}
+	if len(r.secrets) == 0 {
+		return nil, fmt.Errorf("at least one secret is required")
+	}
+
+	for _, route := range strings.Split(routes, ",") {
+		if route = strings.TrimSpace(route); route == "" {
+			continue
+		}
+		prefix, secretID, ok := strings.Cut(route, "=")
+		prefix, secretID = strings.TrimSpace(prefix), strings.TrimSpace(secretID)
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid route: %s", route)
+		}
+		if _, ok := r.secret(secretID); !ok {
+			return nil, fmt.Errorf("unknown route secret: %s", secretID)
+		}
+		r.routes = append(r.routes, secretRoute{prefix: prefix, secretID: secretID})
+	}
+	// the longest prefix takes precedence
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return len(r.routes[i].prefix) > len(r.routes[j].prefix)
+	})
+
+	return r, nil
+}
+
+// secret returns the given secret if it's configured, or the default one if empty.
+func (r *secretRouter) secret(secretID string) (string, bool) {
+	if secretID == "" {
+		return r.secrets[0], true
+	}
+	for _, s := range r.secrets {
+		if s == secretID {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// route returns the secret of the longest route prefix matching the given path at a segment boundary
+// (see secretsmanager.PathHasPrefix), or the default secret if none matches.
+func (r *secretRouter) route(path string) string {
+	for _, route := range r.routes {
+		if secretsmanager.PathHasPrefix(path, route.prefix) {
+			return route.secretID
+		}
+	}
+	return r.secrets[0]
+}
+
+// setWebhooks configures the webhook providers of the secrets. Webhooks are an optional comma separated
+// list of 'secret=provider' pairs (i.e. 'arn1=github,arn2=stripe'), the provider is one of the
+// secretsmanager.WebhookPreset names. The tolerance is the maximum age of the signature timestamps.
+func (r *secretRouter) setWebhooks(webhooks string, tolerance time.Duration) error {
+	r.webhooks = make(map[string]secretsmanager.WebhookVerifier)
+	for _, webhook := range strings.Split(webhooks, ",") {
+		if webhook = strings.TrimSpace(webhook); webhook == "" {
+			continue
+		}
+		secretID, provider, ok := strings.Cut(webhook, "=")
+		secretID, provider = strings.TrimSpace(secretID), strings.TrimSpace(provider)
+		if !ok {
+			return fmt.Errorf("invalid webhook: %s", webhook)
+		}
+		if _, ok := r.secret(secretID); !ok {
+			return fmt.Errorf("unknown webhook secret: %s", secretID)
+		}
+		v, err := secretsmanager.WebhookPreset(provider, tolerance)
+		if err != nil {
+			return err
+		}
+		r.webhooks[secretID] = v
+	}
+	return nil
+}
+
+// webhook returns the webhook verifier of the given secret, if any.
+func (r *secretRouter) webhook(secretID string) (secretsmanager.WebhookVerifier, bool) {
+	v, ok := r.webhooks[secretID]
+	return v, ok
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ln80/secure-lambda-url/secretsmanager"
+)
+
+func TestSecretRouter(t *testing.T) {
+	t.Run("with invalid config", func(t *testing.T) {
+		if _, err := NewSecretRouter([]string{" "}, ""); err == nil {
+			t.Fatal("expect err be not nil")
+		}
+		if _, err := NewSecretRouter([]string{"s1"}, "github=s1"); err == nil {
+			t.Fatal("expect err be not nil")
+		}
+		if _, err := NewSecretRouter([]string{"s1"}, "/github=s2"); err == nil {
+			t.Fatal("expect err be not nil")
+		}
+	})
+
+	t.Run("with valid config", func(t *testing.T) {
+		r, err := NewSecretRouter([]string{"s1", " s2", "s3"}, "/webhooks=s2, /webhooks/stripe=s3")
+		if err != nil {
+			t.Fatal("expect err be nil, got", err)
+		}
+
+		if s, ok := r.secret(""); !ok || s != "s1" {
+			t.Fatalf("expect default secret be s1, got %s", s)
+		}
+		if s, ok := r.secret("s2"); !ok || s != "s2" {
+			t.Fatalf("expect secret be s2, got %s", s)
+		}
+		if _, ok := r.secret("s4"); ok {
+			t.Fatal("expect unknown secret be rejected")
+		}
+
+		for path, want := range map[string]string{
+			"/":                      "s1",
+			"/webhooks/github":       "s2",
+			"/webhooks/stripe/event": "s3",
+			"/webhooks":              "s2",
+			"/webhooksx":             "s1",
+			"/webhooks/stripex":      "s2",
+			"/webhooks/../admin":     "s1",
+		} {
+			if got := r.route(path); got != want {
+				t.Fatalf("expect %s, %s be equals for %s", want, got, path)
+			}
+		}
+	})
+	t.Run("with webhooks", func(t *testing.T) {
+		r, _ := NewSecretRouter([]string{"s1", "s2", "s3"}, "")
+
+		if err := r.setWebhooks("s4=github", time.Minute); err == nil {
+			t.Fatal("expect err be not nil")
+		}
+		if err := r.setWebhooks("s2=unknown", time.Minute); err == nil {
+			t.Fatal("expect err be not nil")
+		}
+
+		if err := r.setWebhooks("s2=github, s3=stripe", time.Minute); err != nil {
+			t.Fatal("expect err be nil, got", err)
+		}
+		if _, ok := r.webhook("s1"); ok {
+			t.Fatal("expect s1 has no webhook")
+		}
+		if v, ok := r.webhook("s2"); !ok || v.Name() != secretsmanager.WebhookGitHub {
+			t.Fatalf("expect s2 webhook be github, got %v", v)
+		}
+		if v, ok := r.webhook("s3"); !ok || v.Name() != secretsmanager.WebhookStripe {
+			t.Fatalf("expect s3 webhook be stripe, got %v", v)
+		}
+	})
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/ln80/secure-lambda-url/cloudfront"
+	"github.com/ln80/secure-lambda-url/rotation"
 	"github.com/ln80/secure-lambda-url/secretsmanager"
 )
 
@@ -17,23 +21,23 @@ type SecretsManagerRotationRequest struct {
 
 type handler func(context.Context, SecretsManagerRotationRequest) error
 
-func makeHandler(distID, customHeader string, rotator secretsmanager.Rotator, updater cloudfront.Updater) handler {
-
-	setSecret := func(ctx context.Context, current, pending string) error {
-		if distID == "" || customHeader == "" {
-			log.Println("WARNING: update dist origin ignored: missed dist ID or Header Name")
-			return nil
+// newTargets returns the downstream targets of the rotation: the origins custom header of each distribution
+// if both the distribution IDs, a comma separated list, and the header name are configured.
+func newTargets(distIDs, customHeader string, updater cloudfront.Updater, opts ...func(*rotation.CloudFrontTargetConfig)) rotation.Targets {
+	var targets rotation.Targets
+	for _, distID := range strings.Split(distIDs, ",") {
+		if distID = strings.TrimSpace(distID); distID == "" || customHeader == "" {
+			continue
 		}
-
-		return updater.Update(ctx, distID, cloudfront.UpdateCustomHeaderFn(customHeader, pending))
+		targets = append(targets, rotation.NewCloudFrontTarget(updater, distID, customHeader, opts...))
 	}
-
-	testSecret := func(ctx context.Context, pending string) error {
-		// at the moment, we directly replace the CURRENT value by the PENDING.
-		// TODO: figure out a simple way to test cloudfront dist custom header change before
-		// finishing the rotation.
-		return nil
+	if len(targets) == 0 {
+		log.Println("WARNING: update dist origin ignored: missed dist ID or Header Name")
 	}
+	return targets
+}
+
+func makeHandler(rotator secretsmanager.Rotator, targets rotation.Targets) handler {
 
 	return func(ctx context.Context, event SecretsManagerRotationRequest) (err error) {
 		defer func() {
@@ -44,6 +48,11 @@ func makeHandler(distID, customHeader string, rotator secretsmanager.Rotator, up
 
 		secret, token, step := event.SecretID, event.ClientRequestToken, event.Step
 
+		// an explicit rollback is allowed even if the rotation is disabled meanwhile
+		if step == secretsmanager.StepRollback {
+			return rotator.Rollback(ctx, secret, token, targets.Rollback)
+		}
+
 		if err = rotator.RotationEnabled(ctx, secret); err != nil {
 			return err
 		}
@@ -52,15 +61,63 @@ func makeHandler(distID, customHeader string, rotator secretsmanager.Rotator, up
 		case secretsmanager.StepCreate:
 			err = rotator.Create(ctx, secret, token)
 		case secretsmanager.StepSet:
-			err = rotator.Set(ctx, secret, token, setSecret)
+			// without targets, no change is pushed downstream nor recorded
+			var set func(ctx context.Context, current, pending string) error
+			if len(targets) > 0 {
+				set = targets.Set
+			}
+			err = rotator.Set(ctx, secret, token, set)
 		case secretsmanager.StepTest:
-			err = rotator.Test(ctx, secret, token, testSecret)
+			err = rotator.Test(ctx, secret, token, targets.Test)
+			if err == nil && len(targets) > 0 {
+				// the deployment time is used by the authorizer to tolerate the previous version
+				// until the new one is served by all the targets, i.e. the cloudfront edge locations
+				err = rotator.RecordDeployment(ctx, secret, token, time.Now())
+			}
 		case secretsmanager.StepFinish:
 			err = rotator.Finish(ctx, secret, token)
 		default:
 			err = fmt.Errorf("%w: %s", secretsmanager.ErrRotationInvalidStep, step)
 		}
 
+		if err != nil && shouldRollback(step, err) {
+			// restore the targets to the current value, which remains the only one accepted once the grace window is over
+			if rbErr := rotator.Rollback(ctx, secret, token, targets.Rollback); rbErr != nil {
+				err = rotation.Errors{err, fmt.Errorf("rollback failed: %w", rbErr)}
+			} else {
+				log.Println("WARNING: rotation rolled back: ", token)
+			}
+		}
+
 		return
 	}
 }
+
+// terminalErrors are the step failures that retrying the rotation won't fix, i.e. a target rejecting
+// the pending value or a missing secret version.
+var terminalErrors = []error{
+	rotation.ErrProbeFailed,
+	cloudfront.ErrNoOriginMatched,
+	secretsmanager.ErrPendingMissing,
+	secretsmanager.ErrCurrentMissing,
+	secretsmanager.ErrInvalidSecretField,
+	secretsmanager.ErrInvalidHeaderValue,
+}
+
+// shouldRollback reports whether the given step failure leaves the targets or the secret in an inconsistent state
+// that retrying the rotation won't fix. Transient failures, such as throttling, network errors or targets
+// which aren't ready yet, are not rolled back: the rotation is retried by secretsmanager instead,
+// and can still be rolled back explicitly using the rollbackSecret step.
+func shouldRollback(step string, err error) bool {
+	switch step {
+	case secretsmanager.StepSet, secretsmanager.StepTest, secretsmanager.StepFinish:
+	default:
+		return false
+	}
+	for _, terminal := range terminalErrors {
+		if errors.Is(err, terminal) {
+			return true
+		}
+	}
+	return false
+}
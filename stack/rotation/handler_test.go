@@ -4,9 +4,16 @@ import (
 	"context"
 	"errors"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
 	"github.com/ln80/secure-lambda-url/cloudfront"
+	"github.com/ln80/secure-lambda-url/rotation"
 	"github.com/ln80/secure-lambda-url/secretsmanager"
 )
 
@@ -15,6 +22,24 @@ var _ = (func() interface{} {
 	return nil
 }())
 
+// withOriginHeader returns a mock update function which applies the update functions
+// to a distribution config whose origin holds the given custom header.
+func withOriginHeader(name string) func(ctx context.Context, distID string, fns ...func(*cloudfront.DistributionConfig)) error {
+	return func(ctx context.Context, distID string, fns ...func(*cloudfront.DistributionConfig)) error {
+		dc := &cloudfront.DistributionConfig{
+			Origins: &types.Origins{Items: []types.Origin{{
+				CustomHeaders: &types.CustomHeaders{Items: []types.OriginCustomHeader{
+					{HeaderName: aws.String(name), HeaderValue: aws.String("cur")},
+				}},
+			}}},
+		}
+		for _, fn := range fns {
+			fn(dc)
+		}
+		return nil
+	}
+}
+
 func TestHandler(t *testing.T) {
 
 	ctx := context.Background()
@@ -23,6 +48,7 @@ func TestHandler(t *testing.T) {
 		dist, customHeader string
 
 		updater cloudfront.Updater
+		targets rotation.Targets
 		rotator secretsmanager.Rotator
 
 		evt SecretsManagerRotationRequest
@@ -95,8 +121,11 @@ func TestHandler(t *testing.T) {
 						return nil
 					},
 					SetFn: func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current, pending string) error) error {
-						// a necessary shallow logic to wire updater into exec process
-						return fn(ctx, "cur", "pen")
+						// without targets, there is nothing to push downstream
+						if fn != nil {
+							return errors.New("unwanted function, should not be passed")
+						}
+						return nil
 					},
 				},
 				evt: SecretsManagerRotationRequest{
@@ -137,15 +166,38 @@ func TestHandler(t *testing.T) {
 				err: infraErr,
 			}
 		}(),
-		// rotation set step succeed
+		// rotation set step failed as no origin holds the custom header
 		func() tc {
 			return tc{
 				dist:         "random",
-				customHeader: "X-Random",
+				customHeader: "X-Randmo",
 				updater: &cloudfront.MockUpdater{
-					UpdateFn: func(ctx context.Context, distID string, fns ...func(*cloudfront.DistributionConfig)) error {
+					UpdateFn: withOriginHeader("X-Random"),
+				},
+				rotator: &secretsmanager.MockRotator{
+					RotationEnabledFn: func(ctx context.Context, secretARN string) error {
 						return nil
 					},
+					SetFn: func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current, pending string) error) error {
+						return fn(ctx, "cur", "pen")
+					},
+				},
+				evt: SecretsManagerRotationRequest{
+					SecretID:           "random",
+					ClientRequestToken: "random",
+					Step:               secretsmanager.StepSet,
+				},
+				ok:  false,
+				err: cloudfront.ErrNoOriginMatched,
+			}
+		}(),
+		// rotation set step succeed
+		func() tc {
+			return tc{
+				dist:         "random",
+				customHeader: "X-Random",
+				updater: &cloudfront.MockUpdater{
+					UpdateFn: withOriginHeader("X-Random"),
 				},
 				rotator: &secretsmanager.MockRotator{
 					RotationEnabledFn: func(ctx context.Context, secretARN string) error {
@@ -165,6 +217,43 @@ func TestHandler(t *testing.T) {
 				err: nil,
 			}
 		}(),
+		// rotation set step fans out to all the targets
+		func() tc {
+			cdnErr := errors.New("cdn error")
+			set := int32(0)
+			spy := func(err error) rotation.Target {
+				return &rotation.MockTarget{
+					SetFn: func(ctx context.Context, current, pending string) error {
+						atomic.AddInt32(&set, 1)
+						return err
+					},
+				}
+			}
+			return tc{
+				targets: rotation.Targets{spy(nil), spy(cdnErr), spy(nil)},
+				rotator: &secretsmanager.MockRotator{
+					RotationEnabledFn: func(ctx context.Context, secretARN string) error {
+						return nil
+					},
+					SetFn: func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current, pending string) error) error {
+						if err := fn(ctx, "cur", "pen"); err != nil {
+							return err
+						}
+						if atomic.LoadInt32(&set) != 3 {
+							return errors.New("expect all targets be set")
+						}
+						return nil
+					},
+				},
+				evt: SecretsManagerRotationRequest{
+					SecretID:           "random",
+					ClientRequestToken: "random",
+					Step:               secretsmanager.StepSet,
+				},
+				ok:  false,
+				err: cdnErr,
+			}
+		}(),
 		// rotation test step succeed
 		func() tc {
 			return tc{
@@ -186,6 +275,71 @@ func TestHandler(t *testing.T) {
 				err: nil,
 			}
 		}(),
+		// rotation test step waits for the cloudfront deployment and records it
+		func() tc {
+			recorded := false
+			return tc{
+				dist:         "random",
+				customHeader: "X-Random",
+				updater: &cloudfront.MockUpdater{
+					WaitDeployedFn: func(ctx context.Context, distID string) error {
+						if recorded {
+							return errors.New("deployment recorded before the distribution is deployed")
+						}
+						return nil
+					},
+				},
+				rotator: &secretsmanager.MockRotator{
+					RotationEnabledFn: func(ctx context.Context, secretARN string) error {
+						return nil
+					},
+					TestFn: func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, pending string) error) error {
+						return fn(ctx, "pen")
+					},
+					RecordDeploymentFn: func(ctx context.Context, secretARN, token string, at time.Time) error {
+						recorded = true
+						return nil
+					},
+				},
+				evt: SecretsManagerRotationRequest{
+					SecretID:           "random",
+					ClientRequestToken: "random",
+					Step:               secretsmanager.StepTest,
+				},
+				ok:  true,
+				err: nil,
+			}
+		}(),
+		// rotation test step failed due to cloudfront deployment timeout
+		func() tc {
+			return tc{
+				dist:         "random",
+				customHeader: "X-Random",
+				updater: &cloudfront.MockUpdater{
+					WaitDeployedFn: func(ctx context.Context, distID string) error {
+						return context.DeadlineExceeded
+					},
+				},
+				rotator: &secretsmanager.MockRotator{
+					RotationEnabledFn: func(ctx context.Context, secretARN string) error {
+						return nil
+					},
+					TestFn: func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, pending string) error) error {
+						return fn(ctx, "pen")
+					},
+					RecordDeploymentFn: func(ctx context.Context, secretARN, token string, at time.Time) error {
+						return errors.New("unwanted error, should not be returned")
+					},
+				},
+				evt: SecretsManagerRotationRequest{
+					SecretID:           "random",
+					ClientRequestToken: "random",
+					Step:               secretsmanager.StepTest,
+				},
+				ok:  false,
+				err: context.DeadlineExceeded,
+			}
+		}(),
 		// rotation finish step failed due infra error
 		func() tc {
 			infraErr := errors.New("infra error")
@@ -231,7 +385,11 @@ func TestHandler(t *testing.T) {
 
 	for i, tc := range tcs {
 		t.Run("tc: "+strconv.Itoa(i+1), func(t *testing.T) {
-			h := makeHandler(tc.dist, tc.customHeader, tc.rotator, tc.updater)
+			targets := tc.targets
+			if targets == nil {
+				targets = newTargets(tc.dist, tc.customHeader, tc.updater)
+			}
+			h := makeHandler(tc.rotator, targets)
 			err := h(ctx, tc.evt)
 			if tc.ok {
 				if err != nil {
@@ -245,3 +403,279 @@ func TestHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestNewTargets(t *testing.T) {
+	ctx := context.Background()
+
+	if targets := newTargets("", "X-Random", &cloudfront.MockUpdater{}); targets != nil {
+		t.Fatalf("expect targets be nil, got %v", targets)
+	}
+	if targets := newTargets("dist1", "", &cloudfront.MockUpdater{}); targets != nil {
+		t.Fatalf("expect targets be nil, got %v", targets)
+	}
+
+	var mu sync.Mutex
+	updated := map[string]int{}
+	updater := &cloudfront.MockUpdater{
+		UpdateFn: func(ctx context.Context, distID string, fns ...func(*cloudfront.DistributionConfig)) error {
+			mu.Lock()
+			updated[distID]++
+			mu.Unlock()
+			return withOriginHeader("X-Random")(ctx, distID, fns...)
+		},
+	}
+
+	targets := newTargets("dist1, dist2,,", "X-Random", updater)
+	if want, got := 2, len(targets); want != got {
+		t.Fatalf("expect %v, %v be equals", want, got)
+	}
+	if err := targets.Set(ctx, "cur", "pen"); err != nil {
+		t.Fatalf("expect err be nil, got %v", err)
+	}
+	for _, distID := range []string{"dist1", "dist2"} {
+		if want, got := 1, updated[distID]; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	}
+}
+
+func TestHandler_Rollback(t *testing.T) {
+	ctx := context.Background()
+
+	type tc struct {
+		name string
+		step string
+
+		enabledErr  error
+		updateErr   error
+		waitErr     error
+		stepErr     error
+		rollbackErr error
+
+		// originHeader is the custom header held by the distribution origin, default to X-Random
+		originHeader string
+
+		err       error
+		rollbacks int
+		header    string
+	}
+
+	infraErr, rollbackErr := errors.New("infra error"), errors.New("rollback error")
+
+	tcs := []tc{
+		{
+			name:      "cloudfront update failed transiently",
+			step:      secretsmanager.StepSet,
+			updateErr: infraErr,
+			err:       infraErr,
+			header:    "pen",
+		},
+		{
+			// the header name is misspelled: the update is rejected, and there is nothing to roll back
+			name:         "cloudfront origin not matched",
+			step:         secretsmanager.StepSet,
+			originHeader: "X-Randon",
+			err:          cloudfront.ErrNoOriginMatched,
+			rollbacks:    1,
+		},
+		{
+			name:    "cloudfront deployment failed transiently",
+			step:    secretsmanager.StepTest,
+			waitErr: infraErr,
+			err:     infraErr,
+		},
+		{
+			name:    "cloudfront deployment in progress",
+			step:    secretsmanager.StepTest,
+			waitErr: context.DeadlineExceeded,
+			err:     rotation.ErrNotReady,
+		},
+		{
+			name:      "pending version missing",
+			step:      secretsmanager.StepTest,
+			stepErr:   secretsmanager.ErrPendingMissing,
+			err:       secretsmanager.ErrPendingMissing,
+			rollbacks: 1,
+			header:    "cur",
+		},
+		{
+			name:    "finish failed transiently",
+			step:    secretsmanager.StepFinish,
+			stepErr: infraErr,
+			err:     infraErr,
+		},
+		{
+			name:      "finish failed",
+			step:      secretsmanager.StepFinish,
+			stepErr:   secretsmanager.ErrCurrentMissing,
+			err:       secretsmanager.ErrCurrentMissing,
+			rollbacks: 1,
+			header:    "cur",
+		},
+		{
+			name:        "rollback failed",
+			step:        secretsmanager.StepFinish,
+			stepErr:     secretsmanager.ErrCurrentMissing,
+			rollbackErr: rollbackErr,
+			err:         rollbackErr,
+			rollbacks:   1,
+		},
+		{
+			name:    "create failed",
+			step:    secretsmanager.StepCreate,
+			stepErr: infraErr,
+			err:     infraErr,
+		},
+		{
+			name:    "token mismatch",
+			step:    secretsmanager.StepSet,
+			stepErr: secretsmanager.ErrTokenMismatch,
+			err:     secretsmanager.ErrTokenMismatch,
+		},
+		{
+			name:       "explicit rollback",
+			step:       secretsmanager.StepRollback,
+			enabledErr: secretsmanager.ErrRotationDisabled,
+			rollbacks:  1,
+			header:     "cur",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			header, rollbacks := "", 0
+			originHeader := tc.originHeader
+			if originHeader == "" {
+				originHeader = "X-Random"
+			}
+			updater := &cloudfront.MockUpdater{
+				UpdateFn: func(ctx context.Context, distID string, fns ...func(*cloudfront.DistributionConfig)) error {
+					dc := &cloudfront.DistributionConfig{
+						Origins: &types.Origins{Items: []types.Origin{{
+							CustomHeaders: &types.CustomHeaders{Items: []types.OriginCustomHeader{
+								{HeaderName: aws.String(originHeader), HeaderValue: aws.String(header)},
+							}},
+						}}},
+					}
+					for _, fn := range fns {
+						fn(dc)
+					}
+					header = aws.ToString(dc.Origins.Items[0].CustomHeaders.Items[0].HeaderValue)
+					if header == "pen" {
+						return tc.updateErr
+					}
+					return nil
+				},
+				WaitDeployedFn: func(ctx context.Context, distID string) error {
+					return tc.waitErr
+				},
+			}
+			rotator := &secretsmanager.MockRotator{
+				RotationEnabledFn: func(ctx context.Context, secretARN string) error {
+					return tc.enabledErr
+				},
+				CreateFn: func(ctx context.Context, secretARN, token string) error {
+					return tc.stepErr
+				},
+				SetFn: func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current, pending string) error) error {
+					if tc.stepErr != nil {
+						return tc.stepErr
+					}
+					return fn(ctx, "cur", "pen")
+				},
+				TestFn: func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, pending string) error) error {
+					if tc.stepErr != nil {
+						return tc.stepErr
+					}
+					return fn(ctx, "pen")
+				},
+				FinishFn: func(ctx context.Context, secretARN, token string) error {
+					return tc.stepErr
+				},
+				RollbackFn: func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current string) error) error {
+					rollbacks++
+					if tc.rollbackErr != nil {
+						return tc.rollbackErr
+					}
+					return fn(ctx, "cur")
+				},
+			}
+
+			h := makeHandler(rotator, newTargets("random", "X-Random", updater))
+			err := h(ctx, SecretsManagerRotationRequest{
+				SecretID:           "random",
+				ClientRequestToken: "random",
+				Step:               tc.step,
+			})
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expect err be %v, got %v", tc.err, err)
+			}
+			if tc.rollbackErr != nil && !errors.Is(err, tc.stepErr) {
+				t.Fatalf("expect err be %v, got %v", tc.stepErr, err)
+			}
+			// a failed rollback is reported along with the step error
+			if want, got := tc.rollbackErr != nil, err != nil && strings.Contains(err.Error(), "rollback failed"); want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := tc.rollbacks, rollbacks; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+			if want, got := tc.header, header; want != got {
+				t.Fatalf("expect %v, %v be equals", want, got)
+			}
+		})
+	}
+
+	t.Run("transient set failure retried", func(t *testing.T) {
+		header, updates, rollbacks := "cur", 0, 0
+		updater := &cloudfront.MockUpdater{
+			UpdateFn: func(ctx context.Context, distID string, fns ...func(*cloudfront.DistributionConfig)) error {
+				// the first update is throttled
+				if updates++; updates == 1 {
+					return infraErr
+				}
+				dc := &cloudfront.DistributionConfig{
+					Origins: &types.Origins{Items: []types.Origin{{
+						CustomHeaders: &types.CustomHeaders{Items: []types.OriginCustomHeader{
+							{HeaderName: aws.String("X-Random"), HeaderValue: aws.String(header)},
+						}},
+					}}},
+				}
+				for _, fn := range fns {
+					fn(dc)
+				}
+				header = aws.ToString(dc.Origins.Items[0].CustomHeaders.Items[0].HeaderValue)
+				return nil
+			},
+		}
+		rotator := &secretsmanager.MockRotator{
+			SetFn: func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current, pending string) error) error {
+				return fn(ctx, "cur", "pen")
+			},
+			RollbackFn: func(ctx context.Context, secretARN, token string, fn func(ctx context.Context, current string) error) error {
+				rollbacks++
+				return fn(ctx, "cur")
+			},
+		}
+
+		h := makeHandler(rotator, newTargets("random", "X-Random", updater))
+		event := SecretsManagerRotationRequest{
+			SecretID:           "random",
+			ClientRequestToken: "random",
+			Step:               secretsmanager.StepSet,
+		}
+		if err := h(ctx, event); !errors.Is(err, infraErr) {
+			t.Fatalf("expect err be %v, got %v", infraErr, err)
+		}
+		// secretsmanager retries the step
+		if err := h(ctx, event); err != nil {
+			t.Fatalf("expect err be nil, got %v", err)
+		}
+		if want, got := 0, rollbacks; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+		if want, got := "pen", header; want != got {
+			t.Fatalf("expect %v, %v be equals", want, got)
+		}
+	})
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/ln80/secure-lambda-url/cloudfront"
+	"github.com/ln80/secure-lambda-url/rotation"
 	"github.com/ln80/secure-lambda-url/secretsmanager"
 )
 
@@ -40,16 +42,41 @@ func init() {
 		log.Fatalln(err, "init dependencies failed")
 	}
 
+	secretClient := secretsmanager.NewClient(cfg, secretEndpoint)
+
+	var generator secretsmanager.Generator
+	if spec := os.Getenv("GENERATOR"); spec != "" {
+		if generator, err = secretsmanager.ParseGenerator(secretClient, spec); err != nil {
+			log.Fatalln(err, "init dependencies failed")
+		}
+	}
+
 	rotator = secretsmanager.NewDefaultRotator(
-		secretsmanager.NewClient(cfg, secretEndpoint))
+		secretClient, func(rc *secretsmanager.RotatorConfig) {
+			rc.SecretField = os.Getenv("SECRET_FIELD")
+			rc.KeyringKey = os.Getenv("KEYRING_KEY")
+			rc.KeyFormat = os.Getenv("KEY_FORMAT_ENABLED") == "true"
+			rc.Generator = generator
+			rc.HeaderSafe = os.Getenv("HEADER_SAFE") == "true"
+		})
 
 	updater = cloudfront.NewDefaultUpdater(
 		cloudfront.NewClient(cfg))
 }
 
 func main() {
-	h := makeHandler(
-		os.Getenv("DISTRIBUTION_ID"), os.Getenv("CUSTOM_HEADER_NAME"), rotator, updater)
+	h := makeHandler(rotator,
+		newTargets(os.Getenv("DISTRIBUTION_ID"), os.Getenv("CUSTOM_HEADER_NAME"), updater,
+			func(c *rotation.CloudFrontTargetConfig) {
+				for _, origin := range strings.Split(os.Getenv("ORIGINS"), ",") {
+					if origin = strings.TrimSpace(origin); origin != "" {
+						c.Origins = append(c.Origins, origin)
+					}
+				}
+				c.AddMissing = os.Getenv("ADD_MISSING_HEADER") == "true"
+				c.ProbePath = os.Getenv("PROBE_PATH")
+				c.FunctionURL = os.Getenv("FUNCTION_URL")
+			}))
 
 	lambda.Start(h)
 }